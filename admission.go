@@ -0,0 +1,130 @@
+package scramauth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// OverloadedError is returned when an AdmissionController turns an
+// operation away, after it waited in the queue for Waited. It unwraps to
+// ErrNoResources, which is what the client is sent.
+type OverloadedError struct {
+	Waited time.Duration
+}
+
+func (e *OverloadedError) Error() string {
+	return fmt.Sprintf("server overloaded, gave up after %s", e.Waited)
+}
+
+func (e *OverloadedError) Unwrap() error {
+	return ErrNoResources
+}
+
+// AdmissionStats is a snapshot of an AdmissionController.
+type AdmissionStats struct {
+	Running  int64
+	Queued   int64
+	Admitted int64
+	Rejected int64
+}
+
+// AdmissionController caps how many expensive operations, key
+// derivations and proof checks, run at once. Operations beyond the cap
+// wait in a queue of bounded length for at most the queue timeout, then
+// fail with an *OverloadedError. It is safe for concurrent use and meant
+// to be shared by all conversations of a process.
+type AdmissionController struct {
+	slots    chan struct{}
+	maxQueue int64
+	timeout  time.Duration
+
+	queued, admitted, rejected int64
+}
+
+// NewAdmissionController runs at most maxConcurrent operations at once and
+// queues at most maxQueue more, each for at most timeout. A zero timeout
+// waits until the context is done.
+func NewAdmissionController(maxConcurrent, maxQueue int, timeout time.Duration) (*AdmissionController, error) {
+	if maxConcurrent < 1 {
+		return nil, fmt.Errorf("admission controller must run at least one operation, not %d", maxConcurrent)
+	}
+	if maxQueue < 0 || timeout < 0 {
+		return nil, errors.New("admission controller queue length and timeout can't be negative")
+	}
+	return &AdmissionController{
+		slots:    make(chan struct{}, maxConcurrent),
+		maxQueue: int64(maxQueue),
+		timeout:  timeout}, nil
+}
+
+// Acquire waits for a slot and returns the function giving it back.
+func (ac *AdmissionController) Acquire(ctx context.Context) (release func(), err error) {
+	select {
+	case ac.slots <- struct{}{}:
+		return ac.admit(), nil
+	default:
+	}
+	if atomic.AddInt64(&ac.queued, 1) > ac.maxQueue {
+		atomic.AddInt64(&ac.queued, -1)
+		atomic.AddInt64(&ac.rejected, 1)
+		return nil, &OverloadedError{}
+	}
+	defer atomic.AddInt64(&ac.queued, -1)
+	start := now()
+	var expired <-chan time.Time
+	if ac.timeout > 0 {
+		timer := time.NewTimer(ac.timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	select {
+	case ac.slots <- struct{}{}:
+		return ac.admit(), nil
+	case <-expired:
+		atomic.AddInt64(&ac.rejected, 1)
+		return nil, &OverloadedError{Waited: now().Sub(start)}
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (ac *AdmissionController) admit() func() {
+	atomic.AddInt64(&ac.admitted, 1)
+	return func() { <-ac.slots }
+}
+
+// Stats returns the current load, e.g. to publish it with expvar.Func.
+func (ac *AdmissionController) Stats() AdmissionStats {
+	return AdmissionStats{
+		Running:  int64(len(ac.slots)),
+		Queued:   atomic.LoadInt64(&ac.queued),
+		Admitted: atomic.LoadInt64(&ac.admitted),
+		Rejected: atomic.LoadInt64(&ac.rejected)}
+}
+
+// WithAdmission makes Verify and SaltedPassword wait for a slot of ac.
+// SaltedPasswordContext returns the *OverloadedError when turned away,
+// SaltedPassword returns nil and leaves the error to Verify.
+func (server *ServerScramAuth) WithAdmission(ac *AdmissionController) *ServerScramAuth {
+	server.scramAuth.admission = ac
+	return server
+}
+
+// WithAdmission returns a copy of the server whose conversations share the
+// slots of ac.
+func (server *Server) WithAdmission(ac *AdmissionController) *Server {
+	s := *server
+	s.admission = ac
+	return &s
+}
+
+// admit waits for a slot when the server has an admission controller.
+func (sa *scramAuth) admit(ctx context.Context) (func(), error) {
+	if sa.admission == nil {
+		return func() {}, nil
+	}
+	return sa.admission.Acquire(ctx)
+}
@@ -0,0 +1,124 @@
+package scramauth
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestAdmissionController(t *testing.T) {
+	ctx := context.Background()
+	ac, err := NewAdmissionController(1, 1, 50*time.Millisecond)
+	if err != nil {
+		t.Fatalf("new admission controller error: %s", err.Error())
+	}
+	release, err := ac.Acquire(ctx)
+	if err != nil {
+		t.Fatalf("acquire error: %s", err.Error())
+	}
+	queued := make(chan error)
+	go func() {
+		_, err := ac.Acquire(ctx)
+		queued <- err
+	}()
+	for ac.Stats().Queued != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	var overloaded *OverloadedError
+	if _, err := ac.Acquire(ctx); !errors.As(err, &overloaded) || overloaded.Waited != 0 {
+		t.Fatalf("expected an immediate OverloadedError with a full queue, got %v", err)
+	}
+	if err := <-queued; !errors.As(err, &overloaded) || overloaded.Waited <= 0 {
+		t.Fatalf("expected an OverloadedError after waiting, got %v", err)
+	}
+	if serverErrorOf(overloaded) != ErrNoResources {
+		t.Fatalf("expected no-resources, got %s", serverErrorOf(overloaded))
+	}
+	if stats := ac.Stats(); stats != (AdmissionStats{Running: 1, Admitted: 1, Rejected: 2}) {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+
+	go func() {
+		_, err := ac.Acquire(ctx)
+		queued <- err
+	}()
+	for ac.Stats().Queued != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	release()
+	if err := <-queued; err != nil {
+		t.Fatalf("expected the queued operation to be admitted, got %v", err)
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := ac.Acquire(cancelled); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+}
+
+func TestServerAdmission(t *testing.T) {
+	ac, _ := NewAdmissionController(1, 0, 0)
+	limiter := NewMemoryAttemptLimiter(AttemptPolicy{MaxFailures: 1, Lockout: time.Hour})
+	server, _ := newTestServer(t, 1)
+	server = server.WithAdmission(ac).WithAttemptLimiter(limiter)
+	client, err := NewClient(SCRAM_SHA_256)
+	if err != nil {
+		t.Fatalf("new client error: %s", err.Error())
+	}
+	release, err := ac.Acquire(context.Background())
+	if err != nil {
+		t.Fatalf("acquire error: %s", err.Error())
+	}
+	if _, err := converse(client, server, "", "user0", "password0"); !errors.Is(err, ErrNoResources) {
+		t.Fatalf("expected ErrNoResources, got %v", err)
+	}
+	release()
+	if _, err := converse(client, server, "", "user0", "password0"); err != nil {
+		t.Fatalf("expected the overload not to count as a failure, got %v", err)
+	}
+}
+
+func TestNewAdmissionControllerRejects(t *testing.T) {
+	for _, c := range []struct {
+		maxConcurrent, maxQueue int
+		timeout                 time.Duration
+	}{{0, 1, 0}, {-1, 1, 0}, {1, -1, 0}, {1, 1, -time.Second}} {
+		if _, err := NewAdmissionController(c.maxConcurrent, c.maxQueue, c.timeout); err == nil {
+			t.Fatalf("%+v accepted", c)
+		}
+	}
+}
+
+func TestSaltedPasswordOverloaded(t *testing.T) {
+	ac, _ := NewAdmissionController(1, 0, 0)
+	limiter := NewMemoryAttemptLimiter(AttemptPolicy{MaxFailures: 1, Lockout: time.Hour})
+	server := NewServerScramAuth(sha256.New, None, nil).WithAdmission(ac).WithAttemptLimiter(limiter)
+	var req bytes.Buffer
+	NewClientScramAuth(sha256.New, None, nil).WriteReqMsg("", "yang-zhong", &req)
+	if err := server.WriteChallengeMsg(&req, func(username []byte) ([]byte, int, error) {
+		return []byte("12345678"), 4096, nil
+	}, &bytes.Buffer{}); err != nil {
+		t.Fatalf("write challenge msg error: %s", err.Error())
+	}
+	release, _ := ac.Acquire(context.Background())
+	defer release()
+	saltedPassword := server.SaltedPassword([]byte("123456"), []byte("12345678"), 4096)
+	if saltedPassword != nil {
+		t.Fatalf("salted password derived while overloaded")
+	}
+	err := server.Verify(bytes.NewBufferString("c=biws,r=abc,p=dg=="), saltedPassword)
+	if !errors.Is(err, ErrNoResources) {
+		t.Fatalf("expected ErrNoResources, got %v", err)
+	}
+	var final bytes.Buffer
+	if err := server.WriteErrorMsg(err, &final); err != nil || final.String() != "e=no-resources" {
+		t.Fatalf("expected e=no-resources, got %q %v", final.String(), err)
+	}
+	if err := limiter.Allow(context.Background(), Attempt{Username: "yang-zhong"}); err != nil {
+		t.Fatalf("expected the overload not to count as a failure, got %v", err)
+	}
+}
@@ -0,0 +1,270 @@
+package scramauth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrTemporarilyLocked is matched with errors.Is by the *LockedError an
+// AttemptLimiter refuses a login with. It is sent to the client as
+// other-error.
+var ErrTemporarilyLocked = errors.New("temporarily locked")
+
+// LockedError is returned for a username or remote address that failed
+// too often, RetryAfter telling when the next attempt is allowed.
+type LockedError struct {
+	RetryAfter time.Duration
+}
+
+func (e *LockedError) Error() string {
+	return fmt.Sprintf("%s, retry after %s", ErrTemporarilyLocked, e.RetryAfter)
+}
+
+func (e *LockedError) Unwrap() error {
+	return ErrTemporarilyLocked
+}
+
+// Attempt identifies a login. RemoteAddr is empty when the server wasn't
+// told the address of the client.
+type Attempt struct {
+	Username   string
+	RemoteAddr string
+}
+
+// AttemptLimiter throttles repeated failures. The server calls Allow once
+// the username is known, before looking it up, and again in Verify, which
+// may run on a conversation resumed from a state token. It calls Record
+// with the outcome of the login: ErrInvalidProof, ErrSecondFactor or the
+// error of the Authorizer, nil once all of them passed, or ErrUnknownUser
+// when the username doesn't exist. Malformed messages, cancelled
+// conversations and overloads aren't recorded.
+type AttemptLimiter interface {
+	Allow(ctx context.Context, attempt Attempt) error
+	Record(ctx context.Context, attempt Attempt, err error)
+}
+
+// AttemptPolicy configures a MemoryAttemptLimiter.
+type AttemptPolicy struct {
+	// Backoff is the delay imposed after a failure, doubled with every
+	// further failure up to MaxBackoff. Zero disables back-off.
+	Backoff, MaxBackoff time.Duration
+	// MaxFailures failures in a row lock the username or address out for
+	// Lockout. Zero disables lockouts.
+	MaxFailures int
+	Lockout     time.Duration
+	// Window is how long failures are remembered without a new one,
+	// DefaultAttemptWindow when zero.
+	Window time.Duration
+	// MaxEntries caps the usernames and addresses tracked at once,
+	// DefaultAttemptEntries when zero. Once full, idle entries are
+	// dropped first, then arbitrary ones.
+	MaxEntries int
+}
+
+const (
+	DefaultAttemptWindow  = 15 * time.Minute
+	DefaultAttemptEntries = 100000
+)
+
+type attemptKey struct {
+	addr bool
+	name string
+}
+
+type attemptState struct {
+	failures int
+	last     time.Time
+	until    time.Time
+}
+
+// MemoryAttemptLimiter is an in-memory AttemptLimiter keeping counters
+// per username and per remote address, safe for concurrent use. A
+// successful login resets the counter of the username, not of the
+// address.
+type MemoryAttemptLimiter struct {
+	policy AttemptPolicy
+	mu     sync.Mutex
+	states map[attemptKey]*attemptState
+	// Idle entries are dropped every sweepEvery new entries.
+	inserts, sweepEvery int
+}
+
+func NewMemoryAttemptLimiter(policy AttemptPolicy) *MemoryAttemptLimiter {
+	if policy.Window <= 0 {
+		policy.Window = DefaultAttemptWindow
+	}
+	if policy.MaxEntries <= 0 {
+		policy.MaxEntries = DefaultAttemptEntries
+	}
+	return &MemoryAttemptLimiter{policy: policy, states: map[attemptKey]*attemptState{}, sweepEvery: 1024}
+}
+
+func (limiter *MemoryAttemptLimiter) Allow(ctx context.Context, attempt Attempt) error {
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+	t := now()
+	var retry time.Duration
+	for _, key := range attemptKeys(attempt) {
+		state, ok := limiter.states[key]
+		if !ok {
+			continue
+		}
+		if t.Before(state.until) {
+			if d := state.until.Sub(t); d > retry {
+				retry = d
+			}
+		} else if limiter.idle(state, t) {
+			delete(limiter.states, key)
+		}
+	}
+	if retry > 0 {
+		return &LockedError{RetryAfter: retry}
+	}
+	return nil
+}
+
+func (limiter *MemoryAttemptLimiter) Record(ctx context.Context, attempt Attempt, err error) {
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+	if err == nil {
+		delete(limiter.states, attemptKey{name: attempt.Username})
+		return
+	}
+	t := now()
+	for _, key := range attemptKeys(attempt) {
+		state, ok := limiter.states[key]
+		if !ok {
+			limiter.makeRoom(t)
+		}
+		if !ok || limiter.idle(state, t) {
+			state = &attemptState{}
+			limiter.states[key] = state
+		}
+		state.failures++
+		state.last = t
+		if d := limiter.delay(state.failures); t.Add(d).After(state.until) {
+			state.until = t.Add(d)
+		}
+	}
+}
+
+// idle reports whether state is unlocked and its failures are forgotten.
+func (limiter *MemoryAttemptLimiter) idle(state *attemptState, t time.Time) bool {
+	return t.Sub(state.last) > limiter.policy.Window && !t.Before(state.until)
+}
+
+// makeRoom is called before adding an entry. It drops the idle entries
+// every sweepEvery new entries, often enough to keep the cost constant per
+// entry, and an arbitrary entry, unlocked if possible, when full.
+func (limiter *MemoryAttemptLimiter) makeRoom(t time.Time) {
+	if limiter.inserts++; limiter.inserts >= limiter.sweepEvery {
+		for key, state := range limiter.states {
+			if limiter.idle(state, t) {
+				delete(limiter.states, key)
+			}
+		}
+		limiter.inserts = 0
+		if limiter.sweepEvery = len(limiter.states) / 2; limiter.sweepEvery < 1024 {
+			limiter.sweepEvery = 1024
+		}
+	}
+	if len(limiter.states) < limiter.policy.MaxEntries {
+		return
+	}
+	var victim attemptKey
+	n := 0
+	for key, state := range limiter.states {
+		if n == 0 || !t.Before(state.until) {
+			victim = key
+		}
+		if n++; n == 8 || !t.Before(state.until) {
+			break
+		}
+	}
+	delete(limiter.states, victim)
+}
+
+// delay returns how long a username or address is refused after its nth
+// failure.
+func (limiter *MemoryAttemptLimiter) delay(failures int) time.Duration {
+	policy := limiter.policy
+	if policy.MaxFailures > 0 && failures >= policy.MaxFailures {
+		return policy.Lockout
+	}
+	if policy.Backoff <= 0 {
+		return 0
+	}
+	d := policy.Backoff
+	for i := 1; i < failures && (policy.MaxBackoff <= 0 || d < policy.MaxBackoff); i++ {
+		d *= 2
+	}
+	if policy.MaxBackoff > 0 && d > policy.MaxBackoff {
+		d = policy.MaxBackoff
+	}
+	return d
+}
+
+// UnlockUser forgets the failures of username.
+func (limiter *MemoryAttemptLimiter) UnlockUser(username string) {
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+	delete(limiter.states, attemptKey{name: username})
+}
+
+// UnlockAddr forgets the failures of the remote address addr.
+func (limiter *MemoryAttemptLimiter) UnlockAddr(addr string) {
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+	delete(limiter.states, attemptKey{addr: true, name: addr})
+}
+
+func attemptKeys(attempt Attempt) []attemptKey {
+	keys := []attemptKey{{name: attempt.Username}}
+	if attempt.RemoteAddr != "" {
+		keys = append(keys, attemptKey{addr: true, name: attempt.RemoteAddr})
+	}
+	return keys
+}
+
+// WithAttemptLimiter makes the server consult limiter before the
+// challenge and before checking the proof, and report the outcome of the
+// check to it.
+func (server *ServerScramAuth) WithAttemptLimiter(limiter AttemptLimiter) *ServerScramAuth {
+	server.scramAuth.limiter = limiter
+	return server
+}
+
+// WithRemoteAddr tells the server the address of the client, for the
+// AttemptLimiter and the audit record.
+func (server *ServerScramAuth) WithRemoteAddr(addr string) *ServerScramAuth {
+	server.scramAuth.remoteAddr = addr
+	return server
+}
+
+// WithAttemptLimiter returns a copy of the server sharing limiter between
+// its conversations.
+func (server *Server) WithAttemptLimiter(limiter AttemptLimiter) *Server {
+	s := *server
+	s.limiter = limiter
+	return &s
+}
+
+func (sa *scramAuth) attempt() Attempt {
+	return Attempt{Username: string(sa.username()), RemoteAddr: sa.remoteAddr}
+}
+
+func (sa *scramAuth) allowAttempt(ctx context.Context) error {
+	if sa.limiter == nil {
+		return nil
+	}
+	return sa.limiter.Allow(ctx, sa.attempt())
+}
+
+func (sa *scramAuth) recordAttempt(ctx context.Context, err error) {
+	if sa.limiter != nil {
+		sa.limiter.Record(ctx, sa.attempt(), err)
+	}
+}
@@ -0,0 +1,172 @@
+package scramauth
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMemoryAttemptLimiter(t *testing.T) {
+	clock := time.Unix(1700000000, 0)
+	now = func() time.Time { return clock }
+	defer func() { now = time.Now }()
+	ctx := context.Background()
+	limiter := NewMemoryAttemptLimiter(AttemptPolicy{
+		Backoff:     time.Second,
+		MaxBackoff:  4 * time.Second,
+		MaxFailures: 5,
+		Lockout:     time.Minute,
+		Window:      10 * time.Minute})
+	attempt := Attempt{Username: "user", RemoteAddr: "192.0.2.1"}
+	retryAfter := func(attempt Attempt) time.Duration {
+		err := limiter.Allow(ctx, attempt)
+		if err == nil {
+			return 0
+		}
+		var locked *LockedError
+		if !errors.As(err, &locked) || !errors.Is(err, ErrTemporarilyLocked) {
+			t.Fatalf("expected a LockedError, got %v", err)
+		}
+		return locked.RetryAfter
+	}
+
+	for i, expected := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 4 * time.Second, time.Minute} {
+		limiter.Record(ctx, attempt, ErrInvalidProof)
+		if got := retryAfter(attempt); got != expected {
+			t.Fatalf("failure %d: expected back-off %s, got %s", i+1, expected, got)
+		}
+	}
+	if got := retryAfter(Attempt{Username: "other", RemoteAddr: "192.0.2.1"}); got != time.Minute {
+		t.Fatalf("expected the address to be locked, got %s", got)
+	}
+	clock = clock.Add(time.Minute)
+	if got := retryAfter(attempt); got != 0 {
+		t.Fatalf("expected the lockout to end, got %s", got)
+	}
+
+	limiter.Record(ctx, attempt, ErrInvalidProof)
+	limiter.UnlockUser("user")
+	if got := retryAfter(Attempt{Username: "user"}); got != 0 {
+		t.Fatalf("expected the user to be unlocked, got %s", got)
+	}
+	limiter.UnlockAddr("192.0.2.1")
+	if got := retryAfter(attempt); got != 0 {
+		t.Fatalf("expected the address to be unlocked, got %s", got)
+	}
+
+	limiter.Record(ctx, attempt, ErrInvalidProof)
+	clock = clock.Add(11 * time.Minute)
+	limiter.Record(ctx, attempt, ErrInvalidProof)
+	if got := retryAfter(attempt); got != time.Second {
+		t.Fatalf("expected failures outside the window to be forgotten, got %s", got)
+	}
+	clock = clock.Add(time.Second)
+	limiter.Record(ctx, attempt, nil)
+	if got := retryAfter(Attempt{Username: "user"}); got != 0 {
+		t.Fatalf("expected a login to reset the user, got %s", got)
+	}
+}
+
+func TestServerAttemptLimiter(t *testing.T) {
+	server, _ := newTestServer(t, 1)
+	server = server.WithAttemptLimiter(NewMemoryAttemptLimiter(AttemptPolicy{MaxFailures: 1, Lockout: time.Hour}))
+	client, err := NewClient(SCRAM_SHA_256)
+	if err != nil {
+		t.Fatalf("new client error: %s", err.Error())
+	}
+	if _, err := converse(client, server, "", "user0", "password1"); !errors.Is(err, ErrInvalidProof) {
+		t.Fatalf("expected ErrInvalidProof, got %v", err)
+	}
+	if _, err := converse(client, server, "", "user0", "password0"); !errors.Is(err, ErrTemporarilyLocked) {
+		t.Fatalf("expected ErrTemporarilyLocked, got %v", err)
+	}
+}
+
+func TestMemoryAttemptLimiterBounded(t *testing.T) {
+	clock := time.Unix(1700000000, 0)
+	now = func() time.Time { return clock }
+	defer func() { now = time.Now }()
+	ctx := context.Background()
+	limiter := NewMemoryAttemptLimiter(AttemptPolicy{Backoff: time.Second, MaxEntries: 4})
+	for i := 0; i < 10; i++ {
+		limiter.Record(ctx, Attempt{Username: fmt.Sprintf("user%d", i)}, ErrInvalidProof)
+	}
+	if len(limiter.states) != 4 {
+		t.Fatalf("expected 4 entries, got %d", len(limiter.states))
+	}
+
+	limiter = NewMemoryAttemptLimiter(AttemptPolicy{Backoff: time.Second})
+	for i := 0; i < 2000; i++ {
+		limiter.Record(ctx, Attempt{Username: fmt.Sprintf("user%d", i)}, ErrInvalidProof)
+		clock = clock.Add(time.Second)
+	}
+	if len(limiter.states) >= 2000 {
+		t.Fatalf("expected entries idle for longer than DefaultAttemptWindow to be dropped, got %d", len(limiter.states))
+	}
+}
+
+type attemptLog struct {
+	records []string
+}
+
+func (log *attemptLog) Allow(context.Context, Attempt) error {
+	return nil
+}
+
+func (log *attemptLog) Record(_ context.Context, attempt Attempt, err error) {
+	log.records = append(log.records, attempt.Username+" "+EventReason(Event{Err: err}))
+}
+
+func TestServerRecordsProofOutcomes(t *testing.T) {
+	log := &attemptLog{}
+	server, _ := newTestServer(t, 1)
+	server = server.WithAttemptLimiter(log)
+	client, err := NewClient(SCRAM_SHA_256)
+	if err != nil {
+		t.Fatalf("new client error: %s", err.Error())
+	}
+	converse(client, server, "", "user0", "password0")
+	converse(client, server, "", "user0", "password1")
+	converse(client, server, "", "nobody", "password0")
+	converse(client, server.WithUnknownUserSimulation([]byte("secret"), 4096), "", "nobody", "password0")
+
+	sc := server.NewConversation(nil)
+	var challenge bytes.Buffer
+	if err := sc.Challenge(context.Background(), bytes.NewBufferString("n,,n=user0,r=abc"), &challenge); err != nil {
+		t.Fatalf("challenge error: %s", err.Error())
+	}
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	sc.Finish(cancelled, bytes.NewBufferString("c=biws,r=abc,p=cHJvb2Y="), &bytes.Buffer{})
+	sc = server.NewConversation(nil)
+	sc.Challenge(context.Background(), bytes.NewBufferString("n,,n=user0,r=abc"), &challenge)
+	sc.Finish(context.Background(), bytes.NewBufferString("c=biws,r=abc"), &bytes.Buffer{})
+
+	expected := "user0 ok,user0 invalid-proof,nobody unknown-user,nobody invalid-proof"
+	if got := strings.Join(log.records, ","); got != expected {
+		t.Fatalf("expected records %s, got %s", expected, got)
+	}
+}
+
+func TestResumedVerifyConsultsLimiter(t *testing.T) {
+	limiter := NewMemoryAttemptLimiter(AttemptPolicy{MaxFailures: 1, Lockout: time.Hour})
+	client, token, challenge := exportedChallenge(t)
+	var res bytes.Buffer
+	if err := client.WriteResMsg(challenge, "123456", &res); err != nil {
+		t.Fatalf("client response error: %s", err.Error())
+	}
+	limiter.Record(context.Background(), Attempt{Username: "yang-zhong"}, ErrInvalidProof)
+	server := NewServerScramAuth(sha256.New, None, nil).WithAttemptLimiter(limiter)
+	if err := server.ResumeState(stateKey, token); err != nil {
+		t.Fatalf("resume state error: %s", err.Error())
+	}
+	saltedPassword := server.SaltedPassword([]byte("123456"), []byte("12345678"), 4096)
+	if err := server.Verify(&res, saltedPassword); !errors.Is(err, ErrTemporarilyLocked) {
+		t.Fatalf("expected ErrTemporarilyLocked, got %v", err)
+	}
+}
@@ -0,0 +1,93 @@
+package scramauth
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+const (
+	AuditSuccess = "success"
+	AuditFailure = "failure"
+)
+
+// AuditRecord describes a completed login. It only holds what the client
+// sent in the clear and the outcome, never the proof, signatures or keys,
+// so records can be kept without redaction.
+type AuditRecord struct {
+	Time           time.Time `json:"time"`
+	Mechanism      string    `json:"mechanism"`
+	Username       string    `json:"username"`
+	Authzid        string    `json:"authzid,omitempty"`
+	RemoteAddr     string    `json:"remote_addr,omitempty"`
+	ChannelBinding CB        `json:"channel_binding"`
+	Outcome        string    `json:"outcome"`
+	// Reason is why the login failed, see EventReason. Simulated unknown
+	// users are recorded as unknown-user.
+	Reason string `json:"reason,omitempty"`
+}
+
+// AuditSink receives the record of every login of a Server. A login whose
+// record can't be stored fails.
+type AuditSink interface {
+	Audit(record AuditRecord) error
+}
+
+// JSONAuditSink writes records as JSON lines, e.g. to a file opened with
+// os.O_APPEND. It is safe for concurrent use.
+type JSONAuditSink struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func NewJSONAuditSink(w io.Writer) *JSONAuditSink {
+	return &JSONAuditSink{w: w}
+}
+
+func (sink *JSONAuditSink) Audit(record AuditRecord) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	return FullWrite(sink.w, append(line, '\n'))
+}
+
+// WithAuditSink returns a copy of the server recording every login to
+// sink, which is called concurrently by the conversations.
+func (server *Server) WithAuditSink(sink AuditSink) *Server {
+	s := *server
+	s.audit = sink
+	return &s
+}
+
+// audit records the outcome of the conversation, err being the error it
+// failed with.
+func (conv *ServerConversation) audit(err error) error {
+	if conv.server.audit == nil {
+		return nil
+	}
+	sa := conv.scramAuth
+	cb := sa.gs2Header.CB
+	if cb == "" {
+		cb = None
+	}
+	record := AuditRecord{
+		Time:           now(),
+		Mechanism:      conv.server.mechanism,
+		Username:       string(sa.username()),
+		Authzid:        string(sa.gs2Header.Authzid),
+		RemoteAddr:     sa.remoteAddr,
+		ChannelBinding: cb,
+		Outcome:        AuditSuccess}
+	if err != nil {
+		record.Outcome = AuditFailure
+		record.Reason = reasonOf(err)
+		if sa.unknownUser {
+			record.Reason = string(ErrUnknownUser)
+		}
+	}
+	return conv.server.audit.Audit(record)
+}
@@ -0,0 +1,66 @@
+package scramauth
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+var errDiskFull = errors.New("disk full")
+
+type failingAuditSink struct{}
+
+func (failingAuditSink) Audit(AuditRecord) error {
+	return errDiskFull
+}
+
+func TestAuditRecords(t *testing.T) {
+	now = func() time.Time { return time.Unix(1700000000, 0).UTC() }
+	defer func() { now = time.Now }()
+	var buf bytes.Buffer
+	server, _ := newTestServer(t, 1)
+	server = server.WithAuditSink(NewJSONAuditSink(&buf))
+	client, err := NewClient(SCRAM_SHA_256)
+	if err != nil {
+		t.Fatalf("new client error: %s", err.Error())
+	}
+	converse(client, server, "", "user0", "password0")
+	converse(client, server, "", "user0", "password1")
+	converse(client, server, "", "nobody", "password0")
+	converse(client, server.WithUnknownUserSimulation([]byte("secret"), 4096), "", "nobody", "password0")
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	expected := []string{
+		`{"time":"2023-11-14T22:13:20Z","mechanism":"SCRAM-SHA-256","username":"user0","channel_binding":"none","outcome":"success"}`,
+		`{"time":"2023-11-14T22:13:20Z","mechanism":"SCRAM-SHA-256","username":"user0","channel_binding":"none","outcome":"failure","reason":"invalid-proof"}`,
+		`{"time":"2023-11-14T22:13:20Z","mechanism":"SCRAM-SHA-256","username":"nobody","channel_binding":"none","outcome":"failure","reason":"unknown-user"}`,
+		`{"time":"2023-11-14T22:13:20Z","mechanism":"SCRAM-SHA-256","username":"nobody","channel_binding":"none","outcome":"failure","reason":"unknown-user"}`,
+	}
+	if len(lines) != len(expected) {
+		t.Fatalf("expected %d records, got\n%s", len(expected), buf.String())
+	}
+	for i, line := range lines {
+		if line != expected[i] {
+			t.Fatalf("expected %s, got %s", expected[i], line)
+		}
+		var record AuditRecord
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("unmarshal error: %s", err.Error())
+		}
+	}
+}
+
+func TestAuditFailureFailsLogin(t *testing.T) {
+	server, _ := newTestServer(t, 1)
+	server = server.WithAuditSink(failingAuditSink{})
+	client, err := NewClient(SCRAM_SHA_256)
+	if err != nil {
+		t.Fatalf("new client error: %s", err.Error())
+	}
+	if _, err := converse(client, server, "", "user0", "password0"); !errors.Is(err, errDiskFull) {
+		t.Fatalf("expected the audit error, got %v", err)
+	}
+}
@@ -0,0 +1,69 @@
+package scramauth
+
+import "errors"
+
+// ErrNotAuthorized is returned by Verify when the authenticated user may
+// not act as the authzid of the gs2 header. It is sent to the client as
+// other-error.
+var ErrNotAuthorized = errors.New("not authorized to act as the requested authzid")
+
+// Authorizer decides whether authcid, the user who authenticated, may act
+// as authzid. authzid is never empty.
+type Authorizer func(authcid, authzid string) error
+
+// DefaultAuthorizer only lets users act as themselves.
+func DefaultAuthorizer(authcid, authzid string) error {
+	if authzid != authcid {
+		return ErrNotAuthorized
+	}
+	return nil
+}
+
+// Identity is the outcome of a successful login: who authenticated and
+// whom they act as. Authzid is Authcid when the client didn't ask for one.
+type Identity struct {
+	Authcid string
+	Authzid string
+}
+
+// WithAuthorizer sets the policy for clients asking to act as another
+// user, DefaultAuthorizer by default.
+func (server *ServerScramAuth) WithAuthorizer(authorize Authorizer) *ServerScramAuth {
+	server.scramAuth.authorize = authorize
+	return server
+}
+
+// Identity returns the identities of the login once Verify succeeded.
+func (server *ServerScramAuth) Identity() (Identity, bool) {
+	if server.scramAuth.identity == nil {
+		return Identity{}, false
+	}
+	return *server.scramAuth.identity, true
+}
+
+// WithAuthorizer returns a copy of the server with the given authzid
+// policy. authorize is called concurrently by the conversations.
+func (server *Server) WithAuthorizer(authorize Authorizer) *Server {
+	s := *server
+	s.authorize = authorize
+	return &s
+}
+
+// authorizeUser checks the authzid of the gs2 header against the policy
+// and records the identities of the login.
+func (sa *scramAuth) authorizeUser() error {
+	identity := Identity{Authcid: string(sa.username()), Authzid: string(sa.gs2Header.Authzid)}
+	if identity.Authzid == "" {
+		identity.Authzid = identity.Authcid
+	} else {
+		authorize := sa.authorize
+		if authorize == nil {
+			authorize = DefaultAuthorizer
+		}
+		if err := authorize(identity.Authcid, identity.Authzid); err != nil {
+			return err
+		}
+	}
+	sa.identity = &identity
+	return nil
+}
@@ -0,0 +1,48 @@
+package scramauth
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestDefaultAuthorizer(t *testing.T) {
+	server, _ := newTestServer(t, 1)
+	client, _ := NewClient(SCRAM_SHA_256)
+	for _, authzid := range []string{"", "user0"} {
+		sc, err := converse(client, server, authzid, "user0", "password0")
+		identity, ok := sc.Identity()
+		if err != nil {
+			t.Fatalf("authzid %q: login error: %s", authzid, err.Error())
+		}
+		if !ok || identity != (Identity{Authcid: "user0", Authzid: "user0"}) {
+			t.Fatalf("authzid %q: unexpected identity %+v", authzid, identity)
+		}
+	}
+	sc, err := converse(client, server, "admin", "user0", "password0")
+	identity, ok := sc.Identity()
+	if !errors.Is(err, ErrNotAuthorized) || ok {
+		t.Fatalf("expected ErrNotAuthorized without identity, got %v, %+v", err, identity)
+	}
+}
+
+func TestAuthorizer(t *testing.T) {
+	server, _ := newTestServer(t, 1)
+	server = server.WithAuthorizer(func(authcid, authzid string) error {
+		if authcid == "user0" && authzid == "admin" {
+			return nil
+		}
+		return ErrNotAuthorized
+	})
+	client, _ := NewClient(SCRAM_SHA_256)
+	sc, err := converse(client, server, "admin", "user0", "password0")
+	identity, ok := sc.Identity()
+	if err != nil {
+		t.Fatalf("login error: %s", err.Error())
+	}
+	if !ok || identity != (Identity{Authcid: "user0", Authzid: "admin"}) {
+		t.Fatalf("unexpected identity %+v", identity)
+	}
+	if _, err := converse(client, server, "root", "user0", "password0"); !errors.Is(err, ErrNotAuthorized) {
+		t.Fatalf("expected ErrNotAuthorized, got %v", err)
+	}
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+
+	scramauth "github.com/yang-zzhong/scram-auth"
+)
+
+// peer carries the messages of one exchange, logging each of them.
+type peer struct {
+	r       *bufio.Reader
+	w       io.Writer
+	framing scramauth.Framing
+	log     io.Writer
+}
+
+func (p *peer) send(msg []byte) error {
+	fmt.Fprintf(p.log, "> %s\n", msg)
+	return p.framing.WriteMessage(p.w, msg)
+}
+
+// recv returns the next message as a reader for the library, which reads
+// each message to EOF.
+func (p *peer) recv() (io.Reader, error) {
+	msg, err := p.framing.ReadMessage(p.r, scramauth.DefaultMaxMessageSize)
+	if err != nil {
+		return nil, err
+	}
+	msg = bytes.TrimSuffix(msg, []byte{'\r'})
+	fmt.Fprintf(p.log, "< %s\n", msg)
+	return bytes.NewReader(msg), nil
+}
+
+func framingFor(name string) (scramauth.Framing, error) {
+	switch name {
+	case "line":
+		return scramauth.DelimiterFraming{Delim: '\n'}, nil
+	case "length":
+		return scramauth.LengthPrefixFraming{}, nil
+	}
+	return nil, fmt.Errorf("unknown framing %s, expected line or length", name)
+}
+
+type exchangeFlags struct {
+	flags     *flag.FlagSet
+	mechanism *string
+	addr      *string
+	framing   *string
+	verbose   *bool
+}
+
+func newExchangeFlags(name, addr, usage string, stderr io.Writer) exchangeFlags {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	return exchangeFlags{
+		flags:     flags,
+		mechanism: flags.String("mechanism", scramauth.SCRAM_SHA_256, "SCRAM mechanism"),
+		addr:      flags.String(addr, "", usage),
+		framing:   flags.String("framing", "line", "line or length, length only over TCP"),
+		verbose:   flags.Bool("verbose", false, "print the AuthMessage and signatures")}
+}
+
+// open returns the peer over conn, or over stdin and stdout without one.
+func (ef exchangeFlags) open(conn net.Conn, lines *bufio.Reader, stdout, stderr io.Writer) (*peer, error) {
+	framing, err := framingFor(*ef.framing)
+	if err != nil {
+		return nil, err
+	}
+	if conn != nil {
+		return &peer{r: bufio.NewReader(conn), w: conn, framing: framing, log: stderr}, nil
+	}
+	if *ef.framing != "line" {
+		return nil, errors.New("stdin and stdout only take line framing")
+	}
+	return &peer{r: lines, w: stdout, framing: framing, log: stderr}, nil
+}
+
+type debugValues interface {
+	AuthMessage() []byte
+	ClientSignature() []byte
+	ServerSignature() []byte
+}
+
+func printDebug(verbose bool, auth debugValues, w io.Writer) {
+	if !verbose {
+		return
+	}
+	fmt.Fprintf(w, "AuthMessage: %s\n", auth.AuthMessage())
+	fmt.Fprintf(w, "ClientSignature: %s\n", base64.StdEncoding.EncodeToString(auth.ClientSignature()))
+	fmt.Fprintf(w, "ServerSignature: %s\n", base64.StdEncoding.EncodeToString(auth.ServerSignature()))
+}
+
+func client(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	ef := newExchangeFlags("client", "connect", "address of the server, stdin and stdout by default", stderr)
+	user := ef.flags.String("user", "user", "username")
+	authzid := ef.flags.String("authzid", "", "authorization identity")
+	if err := ef.flags.Parse(args); err != nil {
+		return err
+	}
+	hashBuild := scramauth.HashBuild(*ef.mechanism)
+	if hashBuild == nil {
+		return fmt.Errorf("unknown mechanism %s", *ef.mechanism)
+	}
+	lines := bufio.NewReader(stdin)
+	password, err := readPassword(stdin, lines, stderr)
+	if err != nil {
+		return err
+	}
+	var conn net.Conn
+	if *ef.addr != "" {
+		if conn, err = net.Dial("tcp", *ef.addr); err != nil {
+			return err
+		}
+		defer conn.Close()
+	}
+	p, err := ef.open(conn, lines, stdout, stderr)
+	if err != nil {
+		return err
+	}
+	auth := scramauth.NewClientScramAuth(hashBuild, scramauth.None, nil).
+		WithKDF(scramauth.KDFFor(*ef.mechanism)).
+		WithIterationPolicy(scramauth.IterationPolicyFor(*ef.mechanism))
+	var buf bytes.Buffer
+	if err := auth.WriteReqMsg(*authzid, *user, &buf); err != nil {
+		return err
+	}
+	if err := p.send(buf.Bytes()); err != nil {
+		return err
+	}
+	r, err := p.recv()
+	if err != nil {
+		return err
+	}
+	buf.Reset()
+	if err := auth.WriteResMsg(r, string(password), &buf); err != nil {
+		return err
+	}
+	printDebug(*ef.verbose, auth, stderr)
+	if err := p.send(buf.Bytes()); err != nil {
+		return err
+	}
+	if r, err = p.recv(); err != nil {
+		return err
+	}
+	if err := auth.Verify(r); err != nil {
+		return err
+	}
+	fmt.Fprintln(stderr, "authenticated")
+	return nil
+}
+
+func server(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	ef := newExchangeFlags("server", "listen", "address to accept one client on, stdin and stdout by default", stderr)
+	verifier := ef.flags.String("verifier", "", "stored verifier, the password is read instead when empty")
+	format := ef.flags.String("format", "rfc5803", "format of -verifier: json, rfc5803, postgres, dovecot, kafka or cyrus")
+	iter := ef.flags.Int("iter", 0, "iteration count without -verifier, the recommended minimum by default")
+	if err := ef.flags.Parse(args); err != nil {
+		return err
+	}
+	lines := bufio.NewReader(stdin)
+	cred, err := serverCredential(*ef.mechanism, *verifier, *format, *iter, stdin, lines, stderr)
+	if err != nil {
+		return err
+	}
+	var conn net.Conn
+	if *ef.addr != "" {
+		l, err := net.Listen("tcp", *ef.addr)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(stderr, "listening on %s\n", l.Addr())
+		conn, err = l.Accept()
+		l.Close()
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+	}
+	p, err := ef.open(conn, lines, stdout, stderr)
+	if err != nil {
+		return err
+	}
+	kdf, err := cred.ParseKDF()
+	if err != nil {
+		return err
+	}
+	auth := scramauth.NewServerScramAuth(scramauth.HashBuild(cred.Mechanism), scramauth.None, nil).WithKDF(kdf)
+	r, err := p.recv()
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if err := auth.WriteChallengeMsg(r, func(username []byte) ([]byte, int, error) {
+		return cred.Salt, cred.Iterations, nil
+	}, &buf); err != nil {
+		return err
+	}
+	if err := p.send(buf.Bytes()); err != nil {
+		return err
+	}
+	if r, err = p.recv(); err != nil {
+		return err
+	}
+	buf.Reset()
+	verifyErr := auth.VerifyCredential(r, cred)
+	if verifyErr != nil {
+		if err := auth.WriteErrorMsg(verifyErr, &buf); err != nil {
+			return err
+		}
+	} else if err := auth.WriteCredentialSignatureMsg(cred, &buf); err != nil {
+		return err
+	}
+	printDebug(*ef.verbose, auth, stderr)
+	if err := p.send(buf.Bytes()); err != nil {
+		return err
+	}
+	if verifyErr != nil {
+		return verifyErr
+	}
+	identity, _ := auth.Identity()
+	fmt.Fprintf(stderr, "authenticated %s as %s\n", identity.Authcid, identity.Authzid)
+	return nil
+}
+
+// serverCredential parses verifier, or derives a credential with a random
+// salt from the password when there is none.
+func serverCredential(mechanism, verifier, format string, iter int, stdin io.Reader, lines *bufio.Reader, stderr io.Writer) (*scramauth.Credential, error) {
+	if verifier != "" {
+		codec, err := codecFor(format, mechanism)
+		if err != nil {
+			return nil, err
+		}
+		return codec.Parse(verifier)
+	}
+	password, err := readPassword(stdin, lines, stderr)
+	if err != nil {
+		return nil, err
+	}
+	if iter == 0 {
+		iter = scramauth.IterationPolicyFor(mechanism).Min
+	}
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	return scramauth.NewCredential(mechanism, password, salt, iter)
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	scramauth "github.com/yang-zzhong/scram-auth"
+)
+
+// runPair runs the client and server subcommands against each other over
+// pipes, the way a user pastes messages between two terminals.
+func runPair(clientArgs, serverArgs []string, clientPassword, serverPassword string) (clientErr, serverErr error, clientLog, serverLog string) {
+	toServer, fromClient := io.Pipe()
+	toClient, fromServer := io.Pipe()
+	var clog, slog bytes.Buffer
+	input := func(password string, r io.Reader) io.Reader {
+		if password == "" {
+			return r
+		}
+		return io.MultiReader(strings.NewReader(password+"\n"), r)
+	}
+	done := make(chan error)
+	go func() {
+		err := run(append([]string{"server"}, serverArgs...), input(serverPassword, toServer), fromServer, &slog)
+		fromServer.Close()
+		done <- err
+	}()
+	clientErr = run(append([]string{"client"}, clientArgs...), input(clientPassword, toClient), fromClient, &clog)
+	fromClient.Close()
+	toClient.Close()
+	serverErr = <-done
+	return clientErr, serverErr, clog.String(), slog.String()
+}
+
+func TestClientServer(t *testing.T) {
+	clientErr, serverErr, clientLog, serverLog := runPair([]string{"-verbose"}, []string{"-verbose"}, "pencil", "pencil")
+	if clientErr != nil || serverErr != nil {
+		t.Fatalf("exchange error: client %v, server %v", clientErr, serverErr)
+	}
+	for _, log := range []string{clientLog, serverLog} {
+		if !strings.Contains(log, "AuthMessage: n=user,r=") || !strings.Contains(log, "ServerSignature: ") || !strings.Contains(log, "authenticated") {
+			t.Fatalf("unexpected log %q", log)
+		}
+	}
+}
+
+func TestClientServerWrongPassword(t *testing.T) {
+	clientErr, serverErr, _, _ := runPair(nil, nil, "pen", "pencil")
+	if !errors.Is(serverErr, scramauth.ErrInvalidProof) || !errors.Is(clientErr, scramauth.ErrInvalidProof) {
+		t.Fatalf("expected ErrInvalidProof, got client %v, server %v", clientErr, serverErr)
+	}
+}
+
+func TestServerWithVerifier(t *testing.T) {
+	verifier := "SCRAM-SHA-256$4096:W22ZaJ0SNY7soEsUEjb6gQ==$WG5d8oPm3OtcPnkdi4Uo7BkeZkBFzpcXkuLmtbsT4qY=:wfPLwcE6nTWhTAmQ7tl2KeoiWGPlZqQxSrmfPwDl2dU="
+	clientErr, serverErr, _, _ := runPair(nil, []string{"-verifier", verifier}, "pencil", "")
+	if clientErr != nil || serverErr != nil {
+		t.Fatalf("exchange error: client %v, server %v", clientErr, serverErr)
+	}
+}
+
+func TestServerWithKDFParameters(t *testing.T) {
+	verifier := `{"mechanism":"X-SCRAM-ARGON2ID-SHA-256","salt":"c2FsdHNhbHRzYWx0c2FsdA==","iterations":1,` +
+		`"stored_key":"Wxrq+otDONI2rmDZ8W8uITHoX7El4FJezqn3KrxBXuo=","server_key":"8dkDrgOeGyfgOxMM9dJnX8AU/reN/3pkk3BOHfUxMlQ=",` +
+		`"kdf":"argon2id;m=256;p=1"}`
+	mechanism := []string{"-mechanism", scramauth.X_SCRAM_ARGON2ID_SHA_256}
+	clientErr, serverErr, _, _ := runPair(mechanism, append(mechanism, "-format", "json", "-verifier", verifier), "pencil", "")
+	if clientErr != nil || serverErr != nil {
+		t.Fatalf("exchange error: client %v, server %v", clientErr, serverErr)
+	}
+}
+
+func TestExchangeFlagsRejected(t *testing.T) {
+	cases := [][]string{
+		{"client", "-framing", "length"},
+		{"client", "-framing", "xml"},
+		{"client", "-mechanism", "SCRAM-MD5"},
+		{"server", "-verifier", "SCRAM-SHA-256$4096"},
+	}
+	for _, args := range cases {
+		if err := run(args, strings.NewReader("pencil\n"), &bytes.Buffer{}, &bytes.Buffer{}); err == nil {
+			t.Fatalf("%v: expected error", args)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+// Command scram generates SCRAM verifiers and checks passwords against
+// them.
+//
+//	scram generate [-mechanism M] [-iter N] [-salt B64] [-format F]
+//	scram verify [-mechanism M] [-format F] [-file PATH]
+//	scram client [-mechanism M] [-user U] [-connect ADDR] [-framing F] [-verbose]
+//	scram server [-mechanism M] [-verifier V -format F] [-listen ADDR] [-framing F] [-verbose]
+//
+// The password is read from the terminal without echo, or from the first
+// line of stdin when it isn't a terminal. verify reads the verifier from
+// -file or, before the password, from the first line of stdin.
+//
+// client and server run one exchange for debugging interop. Without
+// -connect or -listen they print the messages they send on stdout and read
+// the peer's from stdin, one per line, so they can be pasted between
+// terminals. Every message and, with -verbose, the AuthMessage and both
+// signatures are printed on stderr.
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+var errUsage = errors.New("usage: scram generate|verify|client|server [flags]")
+
+func main() {
+	if err := run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr); err != nil {
+		fmt.Fprintln(os.Stderr, "scram:", err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+	switch args[0] {
+	case "generate":
+		return generate(args[1:], stdin, stdout, stderr)
+	case "verify":
+		return verify(args[1:], stdin, stdout, stderr)
+	case "client":
+		return client(args[1:], stdin, stdout, stderr)
+	case "server":
+		return server(args[1:], stdin, stdout, stderr)
+	}
+	return errUsage
+}
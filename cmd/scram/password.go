@@ -0,0 +1,32 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+// readPassword reads the password from the terminal without echo, or the
+// next line of stdin from lines when it is a pipe or a file. lines must
+// wrap stdin, so messages read after the password aren't lost.
+func readPassword(stdin io.Reader, lines *bufio.Reader, stderr io.Writer) ([]byte, error) {
+	if f, ok := stdin.(*os.File); ok && term.IsTerminal(int(f.Fd())) {
+		fmt.Fprint(stderr, "Password: ")
+		defer fmt.Fprintln(stderr)
+		return term.ReadPassword(int(f.Fd()))
+	}
+	line, err := lines.ReadString('\n')
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	line = strings.TrimRight(line, "\r\n")
+	if line == "" {
+		return nil, errors.New("empty password")
+	}
+	return []byte(line), nil
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	scramauth "github.com/yang-zzhong/scram-auth"
+	"github.com/yang-zzhong/scram-auth/credential"
+	"golang.org/x/term"
+)
+
+var errPasswordMismatch = errors.New("password does not match the verifier")
+
+func codecFor(format, mechanism string) (credential.Codec, error) {
+	switch format {
+	case "json":
+		return credential.JSON, nil
+	case "rfc5803":
+		return credential.AuthPassword, nil
+	case "postgres":
+		return credential.PostgreSQL, nil
+	case "dovecot":
+		return credential.Dovecot, nil
+	case "kafka":
+		return credential.Kafka{Mechanism: mechanism}, nil
+	case "cyrus":
+		return credential.Cyrus{Mechanism: mechanism}, nil
+	}
+	return nil, fmt.Errorf("unknown format %s, expected json, rfc5803, postgres, dovecot, kafka or cyrus", format)
+}
+
+func generate(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	flags := flag.NewFlagSet("generate", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	mechanism := flags.String("mechanism", scramauth.SCRAM_SHA_256, "SCRAM mechanism")
+	iter := flags.Int("iter", 0, "iteration count, the recommended minimum of the mechanism by default")
+	salt64 := flags.String("salt", "", "base64 salt, random by default")
+	saltSize := flags.Int("salt-size", 16, "size of the random salt")
+	format := flags.String("format", "json", "json, rfc5803, postgres, dovecot, kafka or cyrus")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	codec, err := codecFor(*format, *mechanism)
+	if err != nil {
+		return err
+	}
+	if *iter == 0 {
+		*iter = scramauth.IterationPolicyFor(*mechanism).Min
+	}
+	var salt []byte
+	if *salt64 != "" {
+		if salt, err = base64.StdEncoding.DecodeString(*salt64); err != nil {
+			return fmt.Errorf("invalid salt: %w", err)
+		}
+	} else {
+		if *saltSize < 1 {
+			return errors.New("salt size must be positive")
+		}
+		salt = make([]byte, *saltSize)
+		if _, err := rand.Read(salt); err != nil {
+			return err
+		}
+	}
+	password, err := readPassword(stdin, bufio.NewReader(stdin), stderr)
+	if err != nil {
+		return err
+	}
+	cred, err := scramauth.NewCredential(*mechanism, password, salt, *iter)
+	if err != nil {
+		return err
+	}
+	s, err := codec.Format(cred)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(stdout, s)
+	return err
+}
+
+func verify(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	flags := flag.NewFlagSet("verify", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	mechanism := flags.String("mechanism", scramauth.SCRAM_SHA_256, "SCRAM mechanism of kafka and cyrus verifiers")
+	format := flags.String("format", "rfc5803", "json, rfc5803, postgres, dovecot, kafka or cyrus")
+	file := flags.String("file", "", "file holding the verifier, read from the first line of stdin by default")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 0 {
+		return errors.New("usage: scram verify [flags], the verifier is read from stdin or -file")
+	}
+	codec, err := codecFor(*format, *mechanism)
+	if err != nil {
+		return err
+	}
+	lines := bufio.NewReader(stdin)
+	verifier, err := readVerifier(*file, stdin, lines, stderr)
+	if err != nil {
+		return err
+	}
+	stored, err := codec.Parse(verifier)
+	if err != nil {
+		return err
+	}
+	password, err := readPassword(stdin, lines, stderr)
+	if err != nil {
+		return err
+	}
+	cred, err := stored.Derive(context.Background(), password)
+	if err != nil {
+		return err
+	}
+	if !hmac.Equal(cred.StoredKey, stored.StoredKey) || !hmac.Equal(cred.ServerKey, stored.ServerKey) {
+		return errPasswordMismatch
+	}
+	_, err = fmt.Fprintln(stdout, "ok")
+	return err
+}
+
+// readVerifier reads the verifier from file or, without one, the next line
+// of stdin, so it never shows up in the process list or shell history.
+func readVerifier(file string, stdin io.Reader, lines *bufio.Reader, stderr io.Writer) (string, error) {
+	var text string
+	if file != "" {
+		b, err := os.ReadFile(file)
+		if err != nil {
+			return "", err
+		}
+		text = string(b)
+	} else {
+		if f, ok := stdin.(*os.File); ok && term.IsTerminal(int(f.Fd())) {
+			fmt.Fprint(stderr, "Verifier: ")
+		}
+		line, err := lines.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return "", err
+		}
+		text = line
+	}
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return "", errors.New("empty verifier")
+	}
+	return text, nil
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestGenerateAndVerify(t *testing.T) {
+	for _, format := range []string{"json", "rfc5803", "postgres", "dovecot", "kafka", "cyrus"} {
+		var out bytes.Buffer
+		if err := run([]string{"generate", "-format", format}, strings.NewReader("pencil\n"), &out, &bytes.Buffer{}); err != nil {
+			t.Fatalf("%s: generate error: %s", format, err.Error())
+		}
+		verifier := strings.TrimSpace(out.String())
+		out.Reset()
+		if err := run([]string{"verify", "-format", format}, strings.NewReader(verifier+"\npencil"), &out, &bytes.Buffer{}); err != nil {
+			t.Fatalf("%s: verify error: %s", format, err.Error())
+		}
+		if out.String() != "ok\n" {
+			t.Fatalf("%s: unexpected output %q", format, out.String())
+		}
+		err := run([]string{"verify", "-format", format}, strings.NewReader(verifier+"\npen\n"), &out, &bytes.Buffer{})
+		if !errors.Is(err, errPasswordMismatch) {
+			t.Fatalf("%s: expected errPasswordMismatch, got %v", format, err)
+		}
+	}
+}
+
+func TestVerifyFromFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "verifier")
+	verifier := "SCRAM-SHA-256$4096:W22ZaJ0SNY7soEsUEjb6gQ==$WG5d8oPm3OtcPnkdi4Uo7BkeZkBFzpcXkuLmtbsT4qY=:wfPLwcE6nTWhTAmQ7tl2KeoiWGPlZqQxSrmfPwDl2dU=\n"
+	if err := os.WriteFile(file, []byte(verifier), 0600); err != nil {
+		t.Fatalf("write verifier error: %s", err.Error())
+	}
+	var out bytes.Buffer
+	if err := run([]string{"verify", "-format", "postgres", "-file", file}, strings.NewReader("pencil\n"), &out, &bytes.Buffer{}); err != nil {
+		t.Fatalf("verify error: %s", err.Error())
+	}
+	if out.String() != "ok\n" {
+		t.Fatalf("unexpected output %q", out.String())
+	}
+	if err := run([]string{"verify", "-format", "postgres", verifier}, strings.NewReader("pencil\n"), &out, &bytes.Buffer{}); err == nil {
+		t.Fatalf("expected a usage error for a verifier in the arguments")
+	}
+}
+
+func TestGenerateWithSalt(t *testing.T) {
+	var out bytes.Buffer
+	args := []string{"generate", "-format", "postgres", "-salt", "W22ZaJ0SNY7soEsUEjb6gQ==", "-iter", "4096"}
+	if err := run(args, strings.NewReader("pencil\n"), &out, &bytes.Buffer{}); err != nil {
+		t.Fatalf("generate error: %s", err.Error())
+	}
+	expected := "SCRAM-SHA-256$4096:W22ZaJ0SNY7soEsUEjb6gQ==$WG5d8oPm3OtcPnkdi4Uo7BkeZkBFzpcXkuLmtbsT4qY=:wfPLwcE6nTWhTAmQ7tl2KeoiWGPlZqQxSrmfPwDl2dU=\n"
+	if out.String() != expected {
+		t.Fatalf("expected %s, got %s", expected, out.String())
+	}
+}
+
+func TestGenerateRejects(t *testing.T) {
+	cases := [][]string{
+		{"generate", "-format", "ldif"},
+		{"generate", "-mechanism", "SCRAM-MD5"},
+		{"generate", "-salt", "%%"},
+		{"hash"},
+	}
+	for _, args := range cases {
+		if err := run(args, strings.NewReader("pencil\n"), &bytes.Buffer{}, &bytes.Buffer{}); err == nil {
+			t.Fatalf("%v: expected error", args)
+		}
+	}
+	if err := run([]string{"generate"}, strings.NewReader("\n"), &bytes.Buffer{}, &bytes.Buffer{}); err == nil {
+		t.Fatalf("expected empty password error")
+	}
+}
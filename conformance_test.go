@@ -0,0 +1,268 @@
+package scramauth
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"testing"
+)
+
+// conformanceVector is a complete exchange. The RFC 5802 and RFC 7677
+// exchanges are the published examples, the others were computed
+// independently of this library.
+type conformanceVector struct {
+	name      string
+	mechanism string
+	cb        CB
+	cbData    []byte
+	authzid   string
+	username  string
+	cNonce    string
+	sNonce    string
+	salt      string
+	iter      int
+
+	clientFirst, serverFirst, clientFinal, serverFinal string
+}
+
+const (
+	rfc7677CNonce = "rOprNGfwEbeRWgbNEkqO"
+	rfc7677SNonce = "%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0"
+	rfc7677Salt   = "W22ZaJ0SNY7soEsUEjb6gQ=="
+	rfc7677Nonce  = rfc7677CNonce + rfc7677SNonce
+)
+
+var conformanceVectors = []conformanceVector{
+	{
+		name: "RFC 5802", mechanism: SCRAM_SHA_1, cb: None, username: "user",
+		cNonce: "fyko+d2lbbFgONRv9qkxdawL", sNonce: "3rfcNHYJY1ZVvWVs7j", salt: "QSXCR+Q6sek8bf92", iter: 4096,
+		clientFirst: "n,,n=user,r=fyko+d2lbbFgONRv9qkxdawL",
+		serverFirst: "r=fyko+d2lbbFgONRv9qkxdawL3rfcNHYJY1ZVvWVs7j,s=QSXCR+Q6sek8bf92,i=4096",
+		clientFinal: "c=biws,r=fyko+d2lbbFgONRv9qkxdawL3rfcNHYJY1ZVvWVs7j,p=v0X8v3Bz2T0CJGbJQyF0X+HI4Ts=",
+		serverFinal: "v=rmF9pqV8S7suAoZWja4dJRkFsKQ=",
+	},
+	{
+		name: "RFC 7677", mechanism: SCRAM_SHA_256, cb: None, username: "user",
+		cNonce: rfc7677CNonce, sNonce: rfc7677SNonce, salt: rfc7677Salt, iter: 4096,
+		clientFirst: "n,,n=user,r=" + rfc7677CNonce,
+		serverFirst: "r=" + rfc7677Nonce + ",s=" + rfc7677Salt + ",i=4096",
+		clientFinal: "c=biws,r=" + rfc7677Nonce + ",p=dHzbZapWIk4jUhN+Ute9ytag9zjfMHgsqmmiz7AndVQ=",
+		serverFinal: "v=6rriTRBi23WpRR/wtup+mMhUZUn/dB5nLTJRsjl95G4=",
+	},
+	{
+		name: "SHA-512", mechanism: SCRAM_SHA_512, cb: None, username: "user",
+		cNonce: rfc7677CNonce, sNonce: rfc7677SNonce, salt: rfc7677Salt, iter: 10000,
+		clientFirst: "n,,n=user,r=" + rfc7677CNonce,
+		serverFirst: "r=" + rfc7677Nonce + ",s=" + rfc7677Salt + ",i=10000",
+		clientFinal: "c=biws,r=" + rfc7677Nonce + ",p=sScffJ11LZ4TfY4PVI/6/9rMIHpix12AijdjQOPWK26er2vRtW/osDSi/hegaCFWfI91sJZd0bevncVEhUg0wQ==",
+		serverFinal: "v=RjtcFh+1kT0TmNH2klLiCXHiJLvMLwWuSSjecIns8FBSn0XXRb3iv2qU96STCkYC2Go0feONylPqhw46oweC5A==",
+	},
+	{
+		name: "SHA3-256", mechanism: SCRAM_SHA3_256, cb: None, username: "user",
+		cNonce: rfc7677CNonce, sNonce: rfc7677SNonce, salt: rfc7677Salt, iter: 10000,
+		clientFirst: "n,,n=user,r=" + rfc7677CNonce,
+		serverFirst: "r=" + rfc7677Nonce + ",s=" + rfc7677Salt + ",i=10000",
+		clientFinal: "c=biws,r=" + rfc7677Nonce + ",p=+ZciD9v3GZ93TWg8hR+4JiLSOrnV1Q5qE7oYQbOBIYQ=",
+		serverFinal: "v=nEHhBSjVWzjCA+rroyYBucOhzfF+WpPuALJXGAzgigY=",
+	},
+	{
+		name: "SHA3-512", mechanism: SCRAM_SHA3_512, cb: None, username: "user",
+		cNonce: rfc7677CNonce, sNonce: rfc7677SNonce, salt: rfc7677Salt, iter: 10000,
+		clientFirst: "n,,n=user,r=" + rfc7677CNonce,
+		serverFirst: "r=" + rfc7677Nonce + ",s=" + rfc7677Salt + ",i=10000",
+		clientFinal: "c=biws,r=" + rfc7677Nonce + ",p=w7KJwAHr41G6lNM26UrzOpQgn/3ShpIyN56yItGdPKPjigA/7Jg2EzrNfnDogx+gRshQUgpBLdzBiWyk0PTBRA==",
+		serverFinal: "v=lUqFbE3XVPlSH1If2QB/7LxFxvWX5tBeBg40TOqtG6Wh98muA13tVrJ3ag5UMVvPQBDQsxrrEz0Jpx83xAop3Q==",
+	},
+	{
+		name: "tls-unique", mechanism: SCRAM_SHA_256_PLUS, cb: TlsUnique, cbData: []byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11}, username: "user",
+		cNonce: rfc7677CNonce, sNonce: rfc7677SNonce, salt: rfc7677Salt, iter: 4096,
+		clientFirst: "p=tls-unique,,n=user,r=" + rfc7677CNonce,
+		serverFirst: "r=" + rfc7677Nonce + ",s=" + rfc7677Salt + ",i=4096",
+		clientFinal: "c=cD10bHMtdW5pcXVlLCwAAQIDBAUGBwgJCgs=,r=" + rfc7677Nonce + ",p=Rr4VnwDlwUO/uvbHAzRRwznbdQOFy5XDW+M3J/2eRsM=",
+		serverFinal: "v=ZJuwKpNCjUerKmZZIEw+5Ekce5mUJI1hCYcv5LoylDQ=",
+	},
+	{
+		name: "tls-server-end-point with authzid", mechanism: SCRAM_SHA_256_PLUS, cb: TlsServerEndPoint, authzid: "admin", username: "user",
+		cbData: []byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 20, 21, 22, 23, 24, 25, 26, 27, 28, 29, 30, 31},
+		cNonce: rfc7677CNonce, sNonce: rfc7677SNonce, salt: rfc7677Salt, iter: 4096,
+		clientFirst: "p=tls-server-end-point,a=admin,n=user,r=" + rfc7677CNonce,
+		serverFirst: "r=" + rfc7677Nonce + ",s=" + rfc7677Salt + ",i=4096",
+		clientFinal: "c=cD10bHMtc2VydmVyLWVuZC1wb2ludCxhPWFkbWluLAABAgMEBQYHCAkKCwwNDg8QERITFBUWFxgZGhscHR4f,r=" + rfc7677Nonce + ",p=DAyVbkbcMOF6ziGp6R3ponk6WmODN17HxYyayZGqfJc=",
+		serverFinal: "v=PcpYoPGUx/kmxwlf2rCYuxOnODzt6Jgzk1RUu+bKWEg=",
+	},
+	{
+		name: "escaped username and authzid", mechanism: SCRAM_SHA_256, cb: None, authzid: "ad=min,x", username: "us,er=",
+		cNonce: rfc7677CNonce, sNonce: rfc7677SNonce, salt: rfc7677Salt, iter: 4096,
+		clientFirst: "n,a=ad=3Dmin=2Cx,n=us=2Cer=3D,r=" + rfc7677CNonce,
+		serverFirst: "r=" + rfc7677Nonce + ",s=" + rfc7677Salt + ",i=4096",
+		clientFinal: "c=bixhPWFkPTNEbWluPTJDeCw=,r=" + rfc7677Nonce + ",p=//cdnzNEfOOWtrTHMz2Kb7uoaJEZfFu8d3eUMytB7J8=",
+		serverFinal: "v=EKIhtOSESDNh5HRyKoXdLKTaKNy8cQxzFY2dVg6L8ek=",
+	},
+}
+
+func fixedNonce(nonce string) func() ([]byte, error) {
+	return func() ([]byte, error) {
+		return []byte(nonce), nil
+	}
+}
+
+func (v conformanceVector) client() *ClientScramAuth {
+	return NewClientScramAuth(HashBuild(v.mechanism), v.cb, v.cbData).WithNonceGenerator(fixedNonce(v.cNonce))
+}
+
+// server lets any user act as any authzid, the vectors are about the wire
+// format and not about authorization.
+func (v conformanceVector) server() *ServerScramAuth {
+	return NewServerScramAuth(HashBuild(v.mechanism), v.cb, v.cbData).
+		WithNonceGenerator(fixedNonce(v.sNonce)).
+		WithAuthorizer(func(authcid, authzid string) error { return nil })
+}
+
+func (v conformanceVector) findSaltIter(username []byte) ([]byte, int, error) {
+	if string(username) != v.username {
+		return nil, 0, ErrUnknownUser
+	}
+	salt, err := base64.StdEncoding.DecodeString(v.salt)
+	return salt, v.iter, err
+}
+
+func TestConformanceClient(t *testing.T) {
+	for _, v := range conformanceVectors {
+		client := v.client()
+		var out bytes.Buffer
+		if err := client.WriteReqMsg(v.authzid, v.username, &out); err != nil {
+			t.Fatalf("%s: write req msg error: %s", v.name, err.Error())
+		}
+		if out.String() != v.clientFirst {
+			t.Fatalf("%s: expected client-first %s, got %s", v.name, v.clientFirst, out.String())
+		}
+		out.Reset()
+		if err := client.WriteResMsg(bytes.NewBufferString(v.serverFirst), "pencil", &out); err != nil {
+			t.Fatalf("%s: write res msg error: %s", v.name, err.Error())
+		}
+		if out.String() != v.clientFinal {
+			t.Fatalf("%s: expected client-final %s, got %s", v.name, v.clientFinal, out.String())
+		}
+		if err := client.Verify(bytes.NewBufferString(v.serverFinal)); err != nil {
+			t.Fatalf("%s: verify error: %s", v.name, err.Error())
+		}
+	}
+}
+
+func TestConformanceServer(t *testing.T) {
+	for _, v := range conformanceVectors {
+		server := v.server()
+		var out bytes.Buffer
+		if err := server.WriteChallengeMsg(bytes.NewBufferString(v.clientFirst), v.findSaltIter, &out); err != nil {
+			t.Fatalf("%s: write challenge msg error: %s", v.name, err.Error())
+		}
+		if out.String() != v.serverFirst {
+			t.Fatalf("%s: expected server-first %s, got %s", v.name, v.serverFirst, out.String())
+		}
+		salt, iter, _ := v.findSaltIter([]byte(v.username))
+		saltedPassword := server.SaltedPassword([]byte("pencil"), salt, iter)
+		if err := server.Verify(bytes.NewBufferString(v.clientFinal), saltedPassword); err != nil {
+			t.Fatalf("%s: verify error: %s", v.name, err.Error())
+		}
+		out.Reset()
+		if err := server.WriteSignatureMsg(nil, saltedPassword, &out); err != nil {
+			t.Fatalf("%s: write signature msg error: %s", v.name, err.Error())
+		}
+		if out.String() != v.serverFinal {
+			t.Fatalf("%s: expected server-final %s, got %s", v.name, v.serverFinal, out.String())
+		}
+	}
+}
+
+// TestConformanceServerErrors replays tampered client messages against the
+// server and checks the e attribute it answers with.
+func TestConformanceServerErrors(t *testing.T) {
+	rfc7677 := conformanceVectors[1]
+	tlsUnique := conformanceVectors[5]
+	cases := []struct {
+		name        string
+		v           conformanceVector
+		serverCB    CB
+		clientFirst string
+		clientFinal string
+		want        ServerError
+	}{
+		{"malformed client-first", rfc7677, None, "n,,n=user,r=" + rfc7677CNonce + ",x", "", ErrInvalidEncoding},
+		{"bad username encoding", rfc7677, None, "n,,n=us=er,r=" + rfc7677CNonce, "", ErrInvalidUsernameEncoding},
+		{"bad authzid encoding", rfc7677, None, "n,a=ad=min,n=user,r=" + rfc7677CNonce, "", ErrInvalidUsernameEncoding},
+		{"mandatory extension", rfc7677, None, "n,,m=ext,n=user,r=" + rfc7677CNonce, "", ErrExtensionsNotSupported},
+		{"unknown user", rfc7677, None, "n,,n=nobody,r=" + rfc7677CNonce, "", ErrUnknownUser},
+		{"malformed client-final", rfc7677, None, rfc7677.clientFirst, "c=biws,r=" + rfc7677Nonce, ErrInvalidEncoding},
+		{"invalid proof", rfc7677, None, rfc7677.clientFirst, "c=biws,r=" + rfc7677Nonce + ",p=dHzbZapWIk4jUhN+Ute9ytag9zjfMHgsqmmiz7AndVM=", ErrInvalidProof},
+		{"nonce mismatch", rfc7677, None, rfc7677.clientFirst, "c=biws,r=" + rfc7677CNonce + "x,p=dHzbZapWIk4jUhN+Ute9ytag9zjfMHgsqmmiz7AndVQ=", ErrOtherError},
+		{"channel binding data", tlsUnique, TlsUnique, tlsUnique.clientFirst, "c=cD10bHMtdW5pcXVlLCwAAQIDBAUGBwgJCgo=,r=" + rfc7677Nonce + ",p=Rr4VnwDlwUO/uvbHAzRRwznbdQOFy5XDW+M3J/2eRsM=", ErrChannelBindingsDontMatch},
+		{"channel binding not used", rfc7677, TlsUnique, rfc7677.clientFirst, rfc7677.clientFinal, ErrChannelBindingsDontMatch},
+		{"server supports channel binding", rfc7677, TlsUnique, "y,,n=user,r=" + rfc7677CNonce, "c=eSws,r=" + rfc7677Nonce + ",p=dHzbZapWIk4jUhN+Ute9ytag9zjfMHgsqmmiz7AndVQ=", ErrServerDoesSupportChannelBinding},
+		{"channel binding not supported", tlsUnique, None, tlsUnique.clientFirst, tlsUnique.clientFinal, ErrChannelBindingNotSupported},
+		{"unsupported channel binding type", tlsUnique, TlsServerEndPoint, tlsUnique.clientFirst, tlsUnique.clientFinal, ErrUnsupportedChannelBindingType},
+	}
+	for _, c := range cases {
+		server := NewServerScramAuth(HashBuild(c.v.mechanism), c.serverCB, c.v.cbData).WithNonceGenerator(fixedNonce(c.v.sNonce))
+		err := server.WriteChallengeMsg(bytes.NewBufferString(c.clientFirst), c.v.findSaltIter, &bytes.Buffer{})
+		if err == nil {
+			salt, iter, _ := c.v.findSaltIter([]byte(c.v.username))
+			err = server.Verify(bytes.NewBufferString(c.clientFinal), server.SaltedPassword([]byte("pencil"), salt, iter))
+		}
+		if err == nil {
+			t.Fatalf("%s: exchange succeeded", c.name)
+		}
+		var out bytes.Buffer
+		if err := server.WriteErrorMsg(err, &out); err != nil {
+			t.Fatalf("%s: write error msg error: %s", c.name, err.Error())
+		}
+		if out.String() != "e="+string(c.want) {
+			t.Fatalf("%s: expected e=%s, got %s (%v)", c.name, c.want, out.String(), err)
+		}
+	}
+}
+
+// TestConformanceClientErrors replays tampered server messages against the
+// client.
+func TestConformanceClientErrors(t *testing.T) {
+	v := conformanceVectors[1]
+	cases := []struct {
+		name        string
+		serverFirst string
+		serverFinal string
+		check       func(error) bool
+	}{
+		{"nonce not extended", "r=" + rfc7677CNonce + ",s=" + rfc7677Salt + ",i=4096", "", func(err error) bool { return err != nil }},
+		{"nonce replaced", "r=x" + rfc7677SNonce + ",s=" + rfc7677Salt + ",i=4096", "", func(err error) bool { return err != nil }},
+		{"malformed server-first", "r=" + rfc7677Nonce + ",i=4096", "", func(err error) bool {
+			var ae *AttributeError
+			return errors.As(err, &ae)
+		}},
+		{"iteration count", "r=" + rfc7677Nonce + ",s=" + rfc7677Salt + ",i=0", "", func(err error) bool {
+			var ae *AttributeError
+			return errors.As(err, &ae)
+		}},
+		{"server signature", v.serverFirst, "v=7rriTRBi23WpRR/wtup+mMhUZUn/dB5nLTJRsjl95G4=", func(err error) bool {
+			return errors.Is(err, ErrServerSignature)
+		}},
+		{"server error", v.serverFirst, "e=invalid-proof", func(err error) bool {
+			return errors.Is(err, ErrInvalidProof)
+		}},
+		{"malformed server-final", v.serverFirst, "v=6rriTRBi23WpRR/wtup+mMhUZUn/dB5nLTJRsjl95G4=,e=other-error", func(err error) bool {
+			var ae *AttributeError
+			return errors.As(err, &ae)
+		}},
+	}
+	for _, c := range cases {
+		client := v.client()
+		if err := client.WriteReqMsg("", v.username, &bytes.Buffer{}); err != nil {
+			t.Fatalf("%s: write req msg error: %s", c.name, err.Error())
+		}
+		err := client.WriteResMsg(bytes.NewBufferString(c.serverFirst), "pencil", &bytes.Buffer{})
+		if err == nil {
+			err = client.Verify(bytes.NewBufferString(c.serverFinal))
+		}
+		if !c.check(err) {
+			t.Fatalf("%s: unexpected error %v", c.name, err)
+		}
+	}
+}
@@ -0,0 +1,242 @@
+package scramauth
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+)
+
+// Server is the configuration of a SCRAM server for one mechanism. A
+// Server is never modified by a login, the With methods return a copy, so
+// it can be shared by any number of goroutines. Each login runs in its own
+// ServerConversation.
+type Server struct {
+	mechanism      string
+	hashBuild      func() hash.Hash
+	channelBinding CB
+	store          CredentialStore
+	framing        Framing
+	maxMessageSize int
+	simulation     *unknownUserSimulation
+	authorize      Authorizer
+	observer       Observer
+	audit          AuditSink
+	limiter        AttemptLimiter
+	admission      *AdmissionController
+}
+
+// NewServer returns a server for mechanism authenticating users against
+// the credentials in store, without channel binding.
+func NewServer(mechanism string, store CredentialStore) (*Server, error) {
+	hashBuild := HashBuild(mechanism)
+	if hashBuild == nil {
+		return nil, fmt.Errorf("unknown mechanism %s", mechanism)
+	}
+	return &Server{
+		mechanism:      mechanism,
+		hashBuild:      hashBuild,
+		channelBinding: None,
+		store:          store}, nil
+}
+
+// WithChannelBinding returns a copy of the server requiring channel
+// binding of type cb, None for none.
+func (server *Server) WithChannelBinding(cb CB) *Server {
+	s := *server
+	s.channelBinding = cb
+	return &s
+}
+
+// WithFraming returns a copy of the server reading and writing messages
+// with framing, accepting messages up to maxMessageSize bytes.
+func (server *Server) WithFraming(framing Framing, maxMessageSize int) *Server {
+	s := *server
+	s.framing, s.maxMessageSize = framing, maxMessageSize
+	return &s
+}
+
+// WithUnknownUserSimulation returns a copy of the server answering users
+// the store doesn't know with a fake salt, see
+// ServerScramAuth.WithUnknownUserSimulation.
+func (server *Server) WithUnknownUserSimulation(secret []byte, iter int) *Server {
+	s := *server
+	s.simulation = &unknownUserSimulation{secret: secret, iter: iter}
+	return &s
+}
+
+// ServerConversation is the state of a single login. It is cheap to create
+// and must not be shared between logins or goroutines.
+type ServerConversation struct {
+	*ServerScramAuth
+	server *Server
+	cred   *Credential
+}
+
+// NewConversation starts a login. cbData is the channel binding data of
+// the connection the login runs on, nil without channel binding.
+func (server *Server) NewConversation(cbData []byte) *ServerConversation {
+	auth := NewServerScramAuth(server.hashBuild, server.channelBinding, cbData).
+		WithKDF(KDFFor(server.mechanism))
+	auth.scramAuth.framing = server.framing
+	auth.scramAuth.maxMessageSize = server.maxMessageSize
+	auth.scramAuth.simulation = server.simulation
+	auth.scramAuth.authorize = server.authorize
+	auth.scramAuth.mechanism = server.mechanism
+	auth.scramAuth.observer = server.observer
+	auth.scramAuth.limiter = server.limiter
+	auth.scramAuth.admission = server.admission
+	return &ServerConversation{ServerScramAuth: auth, server: server}
+}
+
+// Challenge reads the client-first message, looks up the user's
+// credential and writes the server-first message.
+func (conv *ServerConversation) Challenge(ctx context.Context, r io.Reader, w io.Writer) error {
+	err := conv.WriteChallengeMsgContext(ctx, r, func(ctx context.Context, username []byte) ([]byte, int, error) {
+		cred, err := conv.server.store.Credential(ctx, conv.server.mechanism, string(username))
+		if err != nil {
+			return nil, 0, err
+		}
+		kdf, err := kdfOf(conv.server.mechanism, cred.KDF)
+		if err != nil {
+			return nil, 0, err
+		}
+		conv.cred = cred
+		conv.scramAuth.kdf = kdf
+		return cred.Salt, cred.Iterations, nil
+	}, w)
+	if err != nil {
+		conv.audit(err)
+	}
+	return err
+}
+
+// Finish reads the client-final message and writes the server-final
+// message: the server signature, or the error the login failed with, which
+// is also returned. A successful login fails when its audit record can't
+// be stored. After ResumeState the credential is looked up again.
+func (conv *ServerConversation) Finish(ctx context.Context, r io.Reader, w io.Writer) error {
+	err := conv.resumeCredential(ctx)
+	if err == nil {
+		err = conv.VerifyCredentialContext(ctx, r, conv.cred)
+	}
+	if aerr := conv.audit(err); aerr != nil && err == nil {
+		conv.scramAuth.identity = nil
+		err = aerr
+	}
+	if err != nil {
+		if werr := conv.WriteErrorMsg(err, w); werr != nil {
+			return werr
+		}
+		return err
+	}
+	return conv.WriteCredentialSignatureMsg(conv.cred, w)
+}
+
+// resumeCredential looks up the credential of a conversation resumed from
+// a state token. The salt, iteration count and KDF must not have changed
+// since the challenge.
+func (conv *ServerConversation) resumeCredential(ctx context.Context) error {
+	sa := conv.scramAuth
+	if conv.cred != nil || sa.unknownUser {
+		return nil
+	}
+	cred, err := conv.server.store.Credential(ctx, conv.server.mechanism, string(sa.username()))
+	if errors.Is(err, ErrUnknownUser) {
+		sa.recordAttempt(ctx, err)
+		return ErrInvalidProof
+	}
+	if err != nil {
+		return err
+	}
+	sent, _ := sa.attrsOf(MsgServerFirst).Val([]byte{KDFAttr})
+	if kdf, err := kdfOf(conv.server.mechanism, cred.KDF); err != nil || kdf.Attr() != string(sent) {
+		return ErrInvalidProof
+	}
+	if !bytes.Equal(cred.Salt, sa.salt) || cred.Iterations != sa.iter {
+		return ErrInvalidProof
+	}
+	conv.cred = cred
+	return nil
+}
+
+// Client is the configuration of a SCRAM client for one mechanism. Like
+// Server it is never modified by a login and can be shared between
+// goroutines, each login running in its own ClientConversation.
+type Client struct {
+	mechanism      string
+	hashBuild      func() hash.Hash
+	channelBinding CB
+	iterPolicy     IterationPolicy
+	keyCache       KeyCache
+	framing        Framing
+	maxMessageSize int
+	observer       Observer
+}
+
+// NewClient returns a client for mechanism without channel binding,
+// enforcing the recommended iteration policy of the mechanism.
+func NewClient(mechanism string) (*Client, error) {
+	hashBuild := HashBuild(mechanism)
+	if hashBuild == nil {
+		return nil, fmt.Errorf("unknown mechanism %s", mechanism)
+	}
+	return &Client{
+		mechanism:      mechanism,
+		hashBuild:      hashBuild,
+		channelBinding: None,
+		iterPolicy:     IterationPolicyFor(mechanism)}, nil
+}
+
+// WithChannelBinding returns a copy of the client using channel binding of
+// type cb, None for none.
+func (client *Client) WithChannelBinding(cb CB) *Client {
+	c := *client
+	c.channelBinding = cb
+	return &c
+}
+
+// WithIterationPolicy returns a copy of the client enforcing policy.
+func (client *Client) WithIterationPolicy(policy IterationPolicy) *Client {
+	c := *client
+	c.iterPolicy = policy
+	return &c
+}
+
+// WithKeyCache returns a copy of the client sharing cache between its
+// logins. cache must be safe for concurrent use.
+func (client *Client) WithKeyCache(cache KeyCache) *Client {
+	c := *client
+	c.keyCache = cache
+	return &c
+}
+
+// WithFraming returns a copy of the client reading and writing messages
+// with framing, accepting messages up to maxMessageSize bytes.
+func (client *Client) WithFraming(framing Framing, maxMessageSize int) *Client {
+	c := *client
+	c.framing, c.maxMessageSize = framing, maxMessageSize
+	return &c
+}
+
+// ClientConversation is the state of a single login. It is cheap to
+// create and must not be shared between logins or goroutines.
+type ClientConversation struct {
+	*ClientScramAuth
+}
+
+// NewConversation starts a login. cbData is the channel binding data of
+// the connection the login runs on, nil without channel binding.
+func (client *Client) NewConversation(cbData []byte) *ClientConversation {
+	auth := NewClientScramAuth(client.hashBuild, client.channelBinding, cbData).
+		WithIterationPolicy(client.iterPolicy).
+		WithKDF(KDFFor(client.mechanism))
+	auth.scramAuth.mechanism = client.mechanism
+	auth.scramAuth.keyCache = client.keyCache
+	auth.scramAuth.framing = client.framing
+	auth.scramAuth.maxMessageSize = client.maxMessageSize
+	auth.scramAuth.observer = client.observer
+	return &ClientConversation{ClientScramAuth: auth}
+}
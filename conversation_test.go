@@ -0,0 +1,173 @@
+package scramauth
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// converse runs one login of username acting as authzid, returning the
+// server's side of it and the server's error or, failing that, the
+// client's.
+func converse(client *Client, server *Server, authzid, username, password string) (*ServerConversation, error) {
+	ctx := context.Background()
+	cc := client.NewConversation(nil)
+	sc := server.NewConversation(nil)
+	var req, challenge, res, final bytes.Buffer
+	if err := cc.WriteReqMsg(authzid, username, &req); err != nil {
+		return sc, err
+	}
+	if err := sc.Challenge(ctx, &req, &challenge); err != nil {
+		return sc, err
+	}
+	if err := cc.WriteResMsgContext(ctx, &challenge, password, &res); err != nil {
+		return sc, err
+	}
+	err := sc.Finish(ctx, &res, &final)
+	if cerr := cc.VerifyContext(ctx, &final); err == nil {
+		err = cerr
+	}
+	return sc, err
+}
+
+func newTestServer(t testing.TB, users int) (*Server, *MemoryCredentialStore) {
+	store := NewMemoryCredentialStore()
+	for i := 0; i < users; i++ {
+		cred, err := NewCredential(SCRAM_SHA_256, []byte(fmt.Sprintf("password%d", i)), []byte(fmt.Sprintf("salt%d", i)), 4096)
+		if err != nil {
+			t.Fatalf("new credential error: %s", err.Error())
+		}
+		store.Put(fmt.Sprintf("user%d", i), cred)
+	}
+	server, err := NewServer(SCRAM_SHA_256, store)
+	if err != nil {
+		t.Fatalf("new server error: %s", err.Error())
+	}
+	return server, store
+}
+
+func TestConversation(t *testing.T) {
+	server, _ := newTestServer(t, 1)
+	client, err := NewClient(SCRAM_SHA_256)
+	if err != nil {
+		t.Fatalf("new client error: %s", err.Error())
+	}
+	if _, err := converse(client, server, "", "user0", "password0"); err != nil {
+		t.Fatalf("login error: %s", err.Error())
+	}
+	if _, err := converse(client, server, "", "user0", "password1"); !errors.Is(err, ErrInvalidProof) {
+		t.Fatalf("expected ErrInvalidProof, got %v", err)
+	}
+	if _, err := converse(client, server, "", "nobody", "password0"); !errors.Is(err, ErrUnknownUser) {
+		t.Fatalf("expected ErrUnknownUser, got %v", err)
+	}
+	simulating := server.WithUnknownUserSimulation([]byte("secret"), 4096)
+	if _, err := converse(client, simulating, "", "nobody", "password0"); !errors.Is(err, ErrInvalidProof) {
+		t.Fatalf("expected ErrInvalidProof for a simulated user, got %v", err)
+	}
+	if server.simulation != nil {
+		t.Fatalf("WithUnknownUserSimulation modified the server")
+	}
+	if _, err := NewServer("SCRAM-MD5", nil); err == nil {
+		t.Fatalf("expected unknown mechanism error")
+	}
+}
+
+// TestConcurrentConversations runs thousands of logins through one Server
+// and two Clients, meant for the race detector. The shared key cache keeps
+// the client from deriving keys more than once per user.
+func TestConcurrentConversations(t *testing.T) {
+	const users, logins = 8, 2000
+	server, _ := newTestServer(t, users)
+	client, err := NewClient(SCRAM_SHA_256)
+	if err != nil {
+		t.Fatalf("new client error: %s", err.Error())
+	}
+	cached := client.WithKeyCache(NewMemoryKeyCache())
+	for i := 0; i < users; i++ {
+		if _, err := converse(cached, server, "", fmt.Sprintf("user%d", i), fmt.Sprintf("password%d", i)); err != nil {
+			t.Fatalf("warm up error: %s", err.Error())
+		}
+	}
+	var wg sync.WaitGroup
+	errs := make(chan error, logins)
+	for i := 0; i < logins; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			user := fmt.Sprintf("user%d", i%users)
+			// Cached keys stand in for the password, wrong passwords go
+			// through the client without the cache.
+			var err error
+			if i%10 == 0 {
+				_, err = converse(client, server, "", user, "wrong")
+			} else {
+				_, err = converse(cached, server, "", user, "")
+			}
+			if i%10 == 0 && !errors.Is(err, ErrInvalidProof) {
+				errs <- fmt.Errorf("login %d: expected ErrInvalidProof, got %v", i, err)
+			} else if i%10 != 0 && err != nil {
+				errs <- fmt.Errorf("login %d: %w", i, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+}
+
+func TestResumedConversation(t *testing.T) {
+	ctx := context.Background()
+	server, store := newTestServer(t, 1)
+	client, err := NewClient(SCRAM_SHA_256)
+	if err != nil {
+		t.Fatalf("new client error: %s", err.Error())
+	}
+	for _, c := range []struct {
+		password string
+		remove   bool
+		want     error
+	}{
+		{"password0", false, nil},
+		{"password1", false, ErrInvalidProof},
+		{"password0", true, ErrInvalidProof},
+	} {
+		first := server.NewConversation(nil)
+		cc := client.NewConversation(nil)
+		var req, challenge, res, final bytes.Buffer
+		if err := cc.WriteReqMsg("", "user0", &req); err != nil {
+			t.Fatalf("write req msg error: %s", err.Error())
+		}
+		if err := first.Challenge(ctx, &req, &challenge); err != nil {
+			t.Fatalf("challenge error: %s", err.Error())
+		}
+		token, err := first.ExportState(stateKey, time.Minute)
+		if err != nil {
+			t.Fatalf("export state error: %s", err.Error())
+		}
+		if err := cc.WriteResMsgContext(ctx, &challenge, c.password, &res); err != nil {
+			t.Fatalf("write res msg error: %s", err.Error())
+		}
+		if c.remove {
+			store.Delete(SCRAM_SHA_256, "user0")
+		}
+		sc := server.NewConversation(nil)
+		if err := sc.ResumeState(stateKey, token); err != nil {
+			t.Fatalf("resume state error: %s", err.Error())
+		}
+		if err := sc.Finish(ctx, &res, &final); !errors.Is(err, c.want) {
+			t.Fatalf("%s: expected %v, got %v", c.password, c.want, err)
+		}
+		if c.want == nil {
+			if err := cc.VerifyContext(ctx, &final); err != nil {
+				t.Fatalf("client verify error: %s", err.Error())
+			}
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package scramauth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Credential is what a server stores for a user in place of the password,
+// the verifier of RFC 5803. The credential subpackage reads and writes it
+// in the formats of common user databases.
+type Credential struct {
+	Mechanism  string
+	Salt       []byte
+	Iterations int
+	StoredKey  []byte
+	ServerKey  []byte
+	// KDF is the k attribute of the KDF the keys were derived with, the
+	// parameters other than Iterations. Empty means PBKDF2 for the standard
+	// mechanisms and the KDFFor defaults for the memory-hard ones.
+	KDF string
+}
+
+// NewCredential derives the credential of password for mechanism.
+func NewCredential(mechanism string, password, salt []byte, iter int) (*Credential, error) {
+	return NewCredentialContext(context.Background(), mechanism, password, salt, iter)
+}
+
+// NewCredentialContext is NewCredential, giving up with the context error
+// once ctx is done.
+func NewCredentialContext(ctx context.Context, mechanism string, password, salt []byte, iter int) (*Credential, error) {
+	return NewCredentialKDF(ctx, mechanism, KDFFor(mechanism), password, salt, iter)
+}
+
+// NewCredentialKDF is NewCredentialContext deriving the keys with kdf,
+// which must be of the kind mechanism uses. Its parameters are kept in
+// the KDF field.
+func NewCredentialKDF(ctx context.Context, mechanism string, kdf KDF, password, salt []byte, iter int) (*Credential, error) {
+	hashBuild := HashBuild(mechanism)
+	if hashBuild == nil {
+		return nil, fmt.Errorf("unknown mechanism %s", mechanism)
+	}
+	if want, err := kdfOf(mechanism, kdf.Attr()); err != nil || want.Attr() != kdf.Attr() {
+		return nil, fmt.Errorf("KDF %q doesn't fit %s", kdf.Attr(), mechanism)
+	}
+	sa := &scramAuth{hashBuild: hashBuild, kdf: kdf}
+	saltedPassword, err := sa.saltedPassword(ctx, password, salt, iter)
+	if err != nil {
+		return nil, err
+	}
+	defer zero(saltedPassword)
+	return &Credential{
+		Mechanism:  mechanism,
+		Salt:       append([]byte{}, salt...),
+		Iterations: iter,
+		StoredKey:  sa.storedKey(saltedPassword),
+		ServerKey:  sa.hmac(saltedPassword, []byte("Server Key")),
+		KDF:        kdf.Attr()}, nil
+}
+
+// ParseKDF returns the KDF the keys of cred were derived with, e.g. for
+// the WithKDF of a server checking logins against cred.
+func (cred *Credential) ParseKDF() (KDF, error) {
+	return kdfOf(cred.Mechanism, cred.KDF)
+}
+
+// Derive derives the credential of password with the mechanism, salt,
+// iteration count and KDF of cred, e.g. to check a password against it.
+func (cred *Credential) Derive(ctx context.Context, password []byte) (*Credential, error) {
+	kdf, err := cred.ParseKDF()
+	if err != nil {
+		return nil, err
+	}
+	return NewCredentialKDF(ctx, cred.Mechanism, kdf, password, cred.Salt, cred.Iterations)
+}
+
+// Validate checks the mechanism is known, the keys have the size of its
+// hash and KDF is one of its KDFs.
+func (cred *Credential) Validate() error {
+	hashBuild := HashBuild(cred.Mechanism)
+	if hashBuild == nil {
+		return fmt.Errorf("unknown mechanism %s", cred.Mechanism)
+	}
+	if cred.Iterations < 1 {
+		return errors.New("credential iteration count must be positive")
+	}
+	size := hashBuild().Size()
+	if len(cred.StoredKey) != size || len(cred.ServerKey) != size {
+		return fmt.Errorf("credential keys must be %d bytes for %s", size, cred.Mechanism)
+	}
+	if _, err := kdfOf(cred.Mechanism, cred.KDF); err != nil {
+		return fmt.Errorf("credential KDF: %w", err)
+	}
+	return nil
+}
+
+// VerifyCredential checks the client proof against cred. For a simulated
+// unknown user cred is ignored and may be nil; unlike Verify it derives no
+// key, as it derives none for real users either.
+func (server *ServerScramAuth) VerifyCredential(r io.Reader, cred *Credential) error {
+	return server.VerifyCredentialContext(context.Background(), r, cred)
+}
+
+func (server *ServerScramAuth) VerifyCredentialContext(ctx context.Context, r io.Reader, cred *Credential) error {
+	var storedKey []byte
+	if cred != nil {
+		storedKey = cred.StoredKey
+	}
+	return server.scramAuth.serverVerify(ctx, r, storedKey)
+}
+
+// WriteCredentialSignatureMsg writes the server-final message signed with
+// the ServerKey of cred.
+func (server *ServerScramAuth) WriteCredentialSignatureMsg(cred *Credential, w io.Writer) error {
+	return server.scramAuth.serverFinal(cred.ServerKey, w)
+}
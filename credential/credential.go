@@ -0,0 +1,241 @@
+// Package credential reads and writes SCRAM verifiers in the formats user
+// databases store them in, so a server can authenticate existing users
+// without knowing their passwords.
+package credential
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	scramauth "github.com/yang-zzhong/scram-auth"
+)
+
+var ErrInvalidFormat = errors.New("invalid credential format")
+
+// Codec converts credentials from and to one storage format.
+type Codec interface {
+	Parse(s string) (*scramauth.Credential, error)
+	Format(cred *scramauth.Credential) (string, error)
+}
+
+var (
+	// AuthPassword is the LDAP authPassword syntax of RFC 5803:
+	// SCRAM-SHA-256$iter:salt$StoredKey:ServerKey
+	AuthPassword Codec = authPassword{}
+	// PostgreSQL is the rolpassword of pg_authid. It has the RFC 5803
+	// syntax and only SCRAM-SHA-256.
+	PostgreSQL Codec = postgreSQL{}
+	// Dovecot is the SCRAM password scheme of Dovecot:
+	// {SCRAM-SHA-256}iter,salt,StoredKey,ServerKey
+	Dovecot Codec = dovecot{}
+)
+
+// Cyrus is the secret the Cyrus SASL SCRAM plugin stores in the
+// cmusaslsecretSCRAM-* property: iter$salt$StoredKey:ServerKey. The
+// mechanism is the property name and not part of the value.
+type Cyrus struct {
+	Mechanism string
+}
+
+// Kafka is the SCRAM credential Kafka keeps for a user per mechanism:
+// salt=...,stored_key=...,server_key=...,iterations=...
+type Kafka struct {
+	Mechanism string
+}
+
+// JSON is a JSON object with every field of the credential, binary
+// fields in base64. It is the only format keeping KDF parameters other
+// than the defaults of the mechanism.
+var JSON Codec = jsonCodec{}
+
+type authPassword struct{}
+
+func (authPassword) Parse(s string) (*scramauth.Credential, error) {
+	fields := strings.Split(s, "$")
+	if len(fields) != 3 {
+		return nil, ErrInvalidFormat
+	}
+	info := strings.Split(fields[1], ":")
+	value := strings.Split(fields[2], ":")
+	if len(info) != 2 || len(value) != 2 {
+		return nil, ErrInvalidFormat
+	}
+	return decode(fields[0], info[0], info[1], value[0], value[1])
+}
+
+func (authPassword) Format(cred *scramauth.Credential) (string, error) {
+	if err := validate(cred); err != nil {
+		return "", err
+	}
+	f := encode(cred)
+	return fmt.Sprintf("%s$%s:%s$%s:%s", cred.Mechanism, f[0], f[1], f[2], f[3]), nil
+}
+
+type postgreSQL struct{}
+
+func (postgreSQL) Parse(s string) (*scramauth.Credential, error) {
+	cred, err := AuthPassword.Parse(s)
+	if err != nil {
+		return nil, err
+	}
+	if cred.Mechanism != scramauth.SCRAM_SHA_256 {
+		return nil, fmt.Errorf("%w: PostgreSQL only stores %s", ErrInvalidFormat, scramauth.SCRAM_SHA_256)
+	}
+	return cred, nil
+}
+
+func (postgreSQL) Format(cred *scramauth.Credential) (string, error) {
+	if cred.Mechanism != scramauth.SCRAM_SHA_256 {
+		return "", fmt.Errorf("%w: PostgreSQL only stores %s", ErrInvalidFormat, scramauth.SCRAM_SHA_256)
+	}
+	return AuthPassword.Format(cred)
+}
+
+type dovecot struct{}
+
+func (dovecot) Parse(s string) (*scramauth.Credential, error) {
+	end := strings.IndexByte(s, '}')
+	if !strings.HasPrefix(s, "{") || end < 0 {
+		return nil, ErrInvalidFormat
+	}
+	fields := strings.Split(s[end+1:], ",")
+	if len(fields) != 4 {
+		return nil, ErrInvalidFormat
+	}
+	return decode(s[1:end], fields[0], fields[1], fields[2], fields[3])
+}
+
+func (dovecot) Format(cred *scramauth.Credential) (string, error) {
+	if err := validate(cred); err != nil {
+		return "", err
+	}
+	f := encode(cred)
+	return fmt.Sprintf("{%s}%s,%s,%s,%s", cred.Mechanism, f[0], f[1], f[2], f[3]), nil
+}
+
+func (c Cyrus) Parse(s string) (*scramauth.Credential, error) {
+	fields := strings.Split(s, "$")
+	if len(fields) != 3 {
+		return nil, ErrInvalidFormat
+	}
+	value := strings.Split(fields[2], ":")
+	if len(value) != 2 {
+		return nil, ErrInvalidFormat
+	}
+	return decode(c.Mechanism, fields[0], fields[1], value[0], value[1])
+}
+
+func (c Cyrus) Format(cred *scramauth.Credential) (string, error) {
+	if cred.Mechanism != c.Mechanism {
+		return "", fmt.Errorf("%w: credential for %s, not %s", ErrInvalidFormat, cred.Mechanism, c.Mechanism)
+	}
+	if err := validate(cred); err != nil {
+		return "", err
+	}
+	f := encode(cred)
+	return fmt.Sprintf("%s$%s$%s:%s", f[0], f[1], f[2], f[3]), nil
+}
+
+func (k Kafka) Parse(s string) (*scramauth.Credential, error) {
+	values := map[string]string{}
+	for _, field := range strings.Split(s, ",") {
+		kv := strings.SplitN(field, "=", 2)
+		if len(kv) != 2 {
+			return nil, ErrInvalidFormat
+		}
+		if _, ok := values[kv[0]]; ok {
+			return nil, fmt.Errorf("%w: duplicate %s", ErrInvalidFormat, kv[0])
+		}
+		values[kv[0]] = kv[1]
+	}
+	if len(values) != 4 {
+		return nil, ErrInvalidFormat
+	}
+	return decode(k.Mechanism, values["iterations"], values["salt"], values["stored_key"], values["server_key"])
+}
+
+func (k Kafka) Format(cred *scramauth.Credential) (string, error) {
+	if cred.Mechanism != k.Mechanism {
+		return "", fmt.Errorf("%w: credential for %s, not %s", ErrInvalidFormat, cred.Mechanism, k.Mechanism)
+	}
+	if err := validate(cred); err != nil {
+		return "", err
+	}
+	f := encode(cred)
+	return fmt.Sprintf("salt=%s,stored_key=%s,server_key=%s,iterations=%s", f[1], f[2], f[3], f[0]), nil
+}
+
+type jsonCredential struct {
+	Mechanism  string `json:"mechanism"`
+	Salt       []byte `json:"salt"`
+	Iterations int    `json:"iterations"`
+	StoredKey  []byte `json:"stored_key"`
+	ServerKey  []byte `json:"server_key"`
+	KDF        string `json:"kdf,omitempty"`
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Parse(s string) (*scramauth.Credential, error) {
+	var c jsonCredential
+	if err := json.Unmarshal([]byte(s), &c); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidFormat, err.Error())
+	}
+	cred := scramauth.Credential(c)
+	if err := cred.Validate(); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidFormat, err.Error())
+	}
+	return &cred, nil
+}
+
+func (jsonCodec) Format(cred *scramauth.Credential) (string, error) {
+	if err := cred.Validate(); err != nil {
+		return "", err
+	}
+	b, err := json.Marshal(jsonCredential(*cred))
+	return string(b), err
+}
+
+func decode(mechanism, iter, salt, storedKey, serverKey string) (*scramauth.Credential, error) {
+	cred := &scramauth.Credential{Mechanism: mechanism}
+	var err error
+	if cred.Iterations, err = strconv.Atoi(iter); err != nil {
+		return nil, fmt.Errorf("%w: iteration count %q", ErrInvalidFormat, iter)
+	}
+	for _, f := range []struct {
+		dst *[]byte
+		src string
+	}{{&cred.Salt, salt}, {&cred.StoredKey, storedKey}, {&cred.ServerKey, serverKey}} {
+		if *f.dst, err = base64.StdEncoding.Strict().DecodeString(f.src); err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidFormat, err.Error())
+		}
+	}
+	if err := cred.Validate(); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidFormat, err.Error())
+	}
+	return cred, nil
+}
+
+// validate checks cred can be written in a format without room for KDF
+// parameters: its KDF must be the default of its mechanism.
+func validate(cred *scramauth.Credential) error {
+	if err := cred.Validate(); err != nil {
+		return err
+	}
+	if cred.KDF != "" && cred.KDF != scramauth.KDFFor(cred.Mechanism).Attr() {
+		return fmt.Errorf("%w: no room for KDF %s", ErrInvalidFormat, cred.KDF)
+	}
+	return nil
+}
+
+func encode(cred *scramauth.Credential) [4]string {
+	return [4]string{
+		strconv.Itoa(cred.Iterations),
+		base64.StdEncoding.EncodeToString(cred.Salt),
+		base64.StdEncoding.EncodeToString(cred.StoredKey),
+		base64.StdEncoding.EncodeToString(cred.ServerKey)}
+}
@@ -0,0 +1,105 @@
+package credential
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	scramauth "github.com/yang-zzhong/scram-auth"
+)
+
+const pencilSHA256 = "SCRAM-SHA-256$4096:W22ZaJ0SNY7soEsUEjb6gQ==$WG5d8oPm3OtcPnkdi4Uo7BkeZkBFzpcXkuLmtbsT4qY=:wfPLwcE6nTWhTAmQ7tl2KeoiWGPlZqQxSrmfPwDl2dU="
+
+func TestCodecs(t *testing.T) {
+	cred, err := scramauth.NewCredential(scramauth.SCRAM_SHA_256, []byte("pencil"), []byte("[m\x99h\x9d\x125\x8e\xec\xa0K\x14\x126\xfa\x81"), 4096)
+	if err != nil {
+		t.Fatalf("new credential error: %s", err.Error())
+	}
+	cases := []struct {
+		codec Codec
+		s     string
+	}{
+		{AuthPassword, pencilSHA256},
+		{PostgreSQL, pencilSHA256},
+		{Dovecot, "{SCRAM-SHA-256}4096,W22ZaJ0SNY7soEsUEjb6gQ==,WG5d8oPm3OtcPnkdi4Uo7BkeZkBFzpcXkuLmtbsT4qY=,wfPLwcE6nTWhTAmQ7tl2KeoiWGPlZqQxSrmfPwDl2dU="},
+		{Cyrus{Mechanism: scramauth.SCRAM_SHA_256}, "4096$W22ZaJ0SNY7soEsUEjb6gQ==$WG5d8oPm3OtcPnkdi4Uo7BkeZkBFzpcXkuLmtbsT4qY=:wfPLwcE6nTWhTAmQ7tl2KeoiWGPlZqQxSrmfPwDl2dU="},
+		{Kafka{Mechanism: scramauth.SCRAM_SHA_256}, "salt=W22ZaJ0SNY7soEsUEjb6gQ==,stored_key=WG5d8oPm3OtcPnkdi4Uo7BkeZkBFzpcXkuLmtbsT4qY=,server_key=wfPLwcE6nTWhTAmQ7tl2KeoiWGPlZqQxSrmfPwDl2dU=,iterations=4096"},
+		{JSON, `{"mechanism":"SCRAM-SHA-256","salt":"W22ZaJ0SNY7soEsUEjb6gQ==","iterations":4096,"stored_key":"WG5d8oPm3OtcPnkdi4Uo7BkeZkBFzpcXkuLmtbsT4qY=","server_key":"wfPLwcE6nTWhTAmQ7tl2KeoiWGPlZqQxSrmfPwDl2dU="}`},
+	}
+	for _, c := range cases {
+		s, err := c.codec.Format(cred)
+		if err != nil {
+			t.Fatalf("format error: %s", err.Error())
+		}
+		if s != c.s {
+			t.Fatalf("expected %s, got %s", c.s, s)
+		}
+		parsed, err := c.codec.Parse(s)
+		if err != nil {
+			t.Fatalf("parse error: %s", err.Error())
+		}
+		if parsed.Mechanism != cred.Mechanism || parsed.Iterations != cred.Iterations ||
+			!bytes.Equal(parsed.Salt, cred.Salt) || !bytes.Equal(parsed.StoredKey, cred.StoredKey) || !bytes.Equal(parsed.ServerKey, cred.ServerKey) {
+			t.Fatalf("parsed %+v, expected %+v", parsed, cred)
+		}
+	}
+}
+
+func TestCodecsKDF(t *testing.T) {
+	cred, err := scramauth.NewCredentialKDF(context.Background(), scramauth.X_SCRAM_SCRYPT_SHA_256, &scramauth.Scrypt{R: 4, P: 1}, []byte("pencil"), []byte("12345678"), 1024)
+	if err != nil {
+		t.Fatalf("new credential error: %s", err.Error())
+	}
+	s, err := JSON.Format(cred)
+	if err != nil {
+		t.Fatalf("format error: %s", err.Error())
+	}
+	parsed, err := JSON.Parse(s)
+	if err != nil {
+		t.Fatalf("parse error: %s", err.Error())
+	}
+	if parsed.KDF != "scrypt;r=4;p=1" {
+		t.Fatalf("KDF lost in JSON: %s", s)
+	}
+	if _, err := AuthPassword.Format(cred); !errors.Is(err, ErrInvalidFormat) {
+		t.Fatalf("expected ErrInvalidFormat for KDF parameters, got %v", err)
+	}
+}
+
+func TestRFC5803StoredKey(t *testing.T) {
+	cred, err := AuthPassword.Parse("SCRAM-SHA-1$4096:QSXCR+Q6sek8bf92$6dlGYMOdZcOPutkcNY8U2g7vK9Y=:D+CSWLOshSulAsxiupA+qs2/fTE=")
+	if err != nil {
+		t.Fatalf("parse error: %s", err.Error())
+	}
+	derived, err := scramauth.NewCredential(scramauth.SCRAM_SHA_1, []byte("pencil"), cred.Salt, cred.Iterations)
+	if err != nil {
+		t.Fatalf("new credential error: %s", err.Error())
+	}
+	if !bytes.Equal(derived.StoredKey, cred.StoredKey) || !bytes.Equal(derived.ServerKey, cred.ServerKey) {
+		t.Fatalf("derived keys don't match the verifier")
+	}
+}
+
+func TestParseRejects(t *testing.T) {
+	cases := []struct {
+		codec Codec
+		s     string
+	}{
+		{AuthPassword, "SCRAM-SHA-256$4096:W22ZaJ0SNY7soEsUEjb6gQ=="},
+		{AuthPassword, "SCRAM-SHA-256$x:W22ZaJ0SNY7soEsUEjb6gQ==$WG5d8oPm3OtcPnkdi4Uo7BkeZkBFzpcXkuLmtbsT4qY=:wfPLwcE6nTWhTAmQ7tl2KeoiWGPlZqQxSrmfPwDl2dU="},
+		{AuthPassword, "SCRAM-MD5$4096:W22ZaJ0SNY7soEsUEjb6gQ==$WG5d8oPm3OtcPnkdi4Uo7BkeZkBFzpcXkuLmtbsT4qY=:wfPLwcE6nTWhTAmQ7tl2KeoiWGPlZqQxSrmfPwDl2dU="},
+		{AuthPassword, "SCRAM-SHA-1$4096:W22ZaJ0SNY7soEsUEjb6gQ==$WG5d8oPm3OtcPnkdi4Uo7BkeZkBFzpcXkuLmtbsT4qY=:wfPLwcE6nTWhTAmQ7tl2KeoiWGPlZqQxSrmfPwDl2dU="},
+		{PostgreSQL, "SCRAM-SHA-1$4096:QSXCR+Q6sek8bf92$6dlGYMOdZcOPutkcNY8U2g7vK9Y=:D+CSWLOshSulAsxiupA+qs2/fTE="},
+		{Dovecot, "SCRAM-SHA-256}4096,W22ZaJ0SNY7soEsUEjb6gQ==,WG5d8oPm3OtcPnkdi4Uo7BkeZkBFzpcXkuLmtbsT4qY=,wfPLwcE6nTWhTAmQ7tl2KeoiWGPlZqQxSrmfPwDl2dU="},
+		{Dovecot, "{SCRAM-SHA-256}4096,W22ZaJ0SNY7soEsUEjb6gQ,WG5d8oPm3OtcPnkdi4Uo7BkeZkBFzpcXkuLmtbsT4qY=,wfPLwcE6nTWhTAmQ7tl2KeoiWGPlZqQxSrmfPwDl2dU="},
+		{Cyrus{Mechanism: scramauth.SCRAM_SHA_256}, "4096$W22ZaJ0SNY7soEsUEjb6gQ==$WG5d8oPm3OtcPnkdi4Uo7BkeZkBFzpcXkuLmtbsT4qY="},
+		{Kafka{Mechanism: scramauth.SCRAM_SHA_256}, "salt=W22ZaJ0SNY7soEsUEjb6gQ==,salt=W22ZaJ0SNY7soEsUEjb6gQ==,stored_key=WG5d8oPm3OtcPnkdi4Uo7BkeZkBFzpcXkuLmtbsT4qY=,server_key=wfPLwcE6nTWhTAmQ7tl2KeoiWGPlZqQxSrmfPwDl2dU="},
+		{JSON, `{"mechanism":"SCRAM-SHA-256","iterations":4096}`},
+	}
+	for _, c := range cases {
+		if _, err := c.codec.Parse(c.s); !errors.Is(err, ErrInvalidFormat) {
+			t.Fatalf("%s: expected ErrInvalidFormat, got %v", c.s, err)
+		}
+	}
+}
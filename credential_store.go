@@ -0,0 +1,61 @@
+package scramauth
+
+import (
+	"context"
+	"sync"
+)
+
+// CredentialStore looks up the credential a user has for a mechanism. It
+// returns ErrUnknownUser for users it doesn't know.
+type CredentialStore interface {
+	Credential(ctx context.Context, mechanism, username string) (*Credential, error)
+}
+
+type credentialStoreKey struct {
+	mechanism, username string
+}
+
+// MemoryCredentialStore is an in-memory CredentialStore safe for
+// concurrent use.
+type MemoryCredentialStore struct {
+	mu    sync.RWMutex
+	creds map[credentialStoreKey]*Credential
+}
+
+func NewMemoryCredentialStore() *MemoryCredentialStore {
+	return &MemoryCredentialStore{creds: map[credentialStoreKey]*Credential{}}
+}
+
+func (store *MemoryCredentialStore) Credential(ctx context.Context, mechanism, username string) (*Credential, error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+	cred, ok := store.creds[credentialStoreKey{mechanism, username}]
+	if !ok {
+		return nil, ErrUnknownUser
+	}
+	return cred.clone(), nil
+}
+
+// Put stores cred for username under cred.Mechanism.
+func (store *MemoryCredentialStore) Put(username string, cred *Credential) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	store.creds[credentialStoreKey{cred.Mechanism, username}] = cred.clone()
+}
+
+// Delete drops the credential of username for mechanism.
+func (store *MemoryCredentialStore) Delete(mechanism, username string) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	delete(store.creds, credentialStoreKey{mechanism, username})
+}
+
+func (cred *Credential) clone() *Credential {
+	return &Credential{
+		Mechanism:  cred.Mechanism,
+		Salt:       append([]byte{}, cred.Salt...),
+		Iterations: cred.Iterations,
+		StoredKey:  append([]byte{}, cred.StoredKey...),
+		ServerKey:  append([]byte{}, cred.ServerKey...),
+		KDF:        cred.KDF}
+}
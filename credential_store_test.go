@@ -0,0 +1,28 @@
+package scramauth
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestMemoryCredentialStore(t *testing.T) {
+	store := NewMemoryCredentialStore()
+	cred, _ := NewCredential(SCRAM_SHA_256, []byte("pencil"), []byte("12345678"), 4096)
+	store.Put("user", cred)
+	got, err := store.Credential(context.Background(), SCRAM_SHA_256, "user")
+	if err != nil {
+		t.Fatalf("credential error: %s", err.Error())
+	}
+	got.StoredKey[0] ^= 1
+	if again, _ := store.Credential(context.Background(), SCRAM_SHA_256, "user"); again.StoredKey[0] != cred.StoredKey[0] {
+		t.Fatalf("store returned its own copy of the credential")
+	}
+	if _, err := store.Credential(context.Background(), SCRAM_SHA_1, "user"); !errors.Is(err, ErrUnknownUser) {
+		t.Fatalf("expected ErrUnknownUser for another mechanism, got %v", err)
+	}
+	store.Delete(SCRAM_SHA_256, "user")
+	if _, err := store.Credential(context.Background(), SCRAM_SHA_256, "user"); !errors.Is(err, ErrUnknownUser) {
+		t.Fatalf("expected ErrUnknownUser after delete, got %v", err)
+	}
+}
@@ -0,0 +1,74 @@
+package scramauth
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"testing"
+)
+
+func TestCredential(t *testing.T) {
+	cred, err := NewCredential(SCRAM_SHA_256, []byte("pencil"), []byte("12345678"), 4096)
+	if err != nil {
+		t.Fatalf("new credential error: %s", err.Error())
+	}
+	login := func(password string) error {
+		return runExchange(NewClientScramAuth(sha256.New, None, nil), NewServerScramAuth(sha256.New, None, nil), password, credentialKeys(cred))
+	}
+	if err := login("pencil"); err != nil {
+		t.Fatalf("login error: %s", err.Error())
+	}
+	if err := login("pen"); !errors.Is(err, ErrInvalidProof) {
+		t.Fatalf("expected ErrInvalidProof, got %v", err)
+	}
+}
+
+func TestCredentialValidate(t *testing.T) {
+	if _, err := NewCredential("SCRAM-MD5", []byte("pencil"), []byte("12345678"), 4096); err == nil {
+		t.Fatalf("expected unknown mechanism error")
+	}
+	cred, _ := NewCredential(SCRAM_SHA_256, []byte("pencil"), []byte("12345678"), 4096)
+	if err := cred.Validate(); err != nil {
+		t.Fatalf("validate error: %s", err.Error())
+	}
+	cred.Mechanism = SCRAM_SHA_1
+	if err := cred.Validate(); err == nil {
+		t.Fatalf("expected key size error")
+	}
+}
+
+func TestCredentialKDF(t *testing.T) {
+	ctx := context.Background()
+	cred, err := NewCredentialKDF(ctx, X_SCRAM_ARGON2ID_SHA_256, &Argon2id{Memory: 256, Threads: 1}, []byte("pencil"), []byte("12345678"), 2)
+	if err != nil {
+		t.Fatalf("new credential error: %s", err.Error())
+	}
+	if cred.KDF != "argon2id;m=256;p=1" {
+		t.Fatalf("unexpected credential KDF %q", cred.KDF)
+	}
+	store := NewMemoryCredentialStore()
+	store.Put("user", cred)
+	server, _ := NewServer(X_SCRAM_ARGON2ID_SHA_256, store)
+	client, _ := NewClient(X_SCRAM_ARGON2ID_SHA_256)
+	if _, err := converse(client, server, "", "user", "pencil"); err != nil {
+		t.Fatalf("login error: %s", err.Error())
+	}
+	if _, err := NewCredentialKDF(ctx, SCRAM_SHA_256, &Argon2id{Memory: 256, Threads: 1}, []byte("pencil"), []byte("12345678"), 2); err == nil {
+		t.Fatalf("expected an error for argon2id with %s", SCRAM_SHA_256)
+	}
+	if _, err := NewCredentialKDF(ctx, X_SCRAM_ARGON2ID_SHA_256, &PBKDF2{Hash: sha256.New}, []byte("pencil"), []byte("12345678"), 4096); err == nil {
+		t.Fatalf("expected an error for pbkdf2 with %s", X_SCRAM_ARGON2ID_SHA_256)
+	}
+	derived, err := cred.Derive(ctx, []byte("pencil"))
+	if err != nil {
+		t.Fatalf("derive error: %s", err.Error())
+	}
+	if !bytes.Equal(derived.StoredKey, cred.StoredKey) || derived.KDF != cred.KDF {
+		t.Fatalf("derived credential doesn't match")
+	}
+	cred.KDF = "scrypt;r=8;p=1"
+	if err := cred.Validate(); err == nil {
+		t.Fatalf("expected a KDF error")
+	}
+}
@@ -0,0 +1,173 @@
+package scramauth
+
+// MessageKind identifies one of the four messages of a conversation.
+type MessageKind int
+
+const (
+	MsgClientFirst MessageKind = iota
+	MsgServerFirst
+	MsgClientFinal
+	MsgServerFinal
+)
+
+func (kind MessageKind) String() string {
+	switch kind {
+	case MsgClientFirst:
+		return "client-first-message"
+	case MsgServerFirst:
+		return "server-first-message"
+	case MsgClientFinal:
+		return "client-final-message"
+	case MsgServerFinal:
+		return "server-final-message"
+	}
+	return "unknown-message"
+}
+
+func (kind MessageKind) grammar() Grammar {
+	switch kind {
+	case MsgClientFirst:
+		return ClientFirstBareGrammar
+	case MsgServerFirst:
+		return ServerFirstGrammar
+	case MsgClientFinal:
+		return ClientFinalGrammar
+	}
+	return ServerFinalGrammar
+}
+
+// Extension adds extension attributes, the ones after the mandatory
+// attributes of a message (RFC 5802 section 7), to the messages of a
+// conversation and reads the ones the peer sent. A side calls Write for
+// the messages it sends and Read for the messages it receives, in the
+// order of the conversation, so an extension can answer the peer. The
+// server reads the client-final extensions only once the proof checks out.
+type Extension interface {
+	Write(kind MessageKind) ([]Param, error)
+	Read(kind MessageKind, attrs *Params) error
+}
+
+// AuthMessageContributor is implemented by extensions binding data that
+// isn't carried in the messages into the AuthMessage, and so into the
+// client proof and server signature. Both sides must contribute the same
+// data.
+type AuthMessageContributor interface {
+	AuthMessageData() []byte
+}
+
+// UserExtension is implemented by server extensions depending on the
+// user. The server calls SetUser with the unescaped username and the
+// authzid of the client-first message before reading its extensions.
+type UserExtension interface {
+	SetUser(username, authzid []byte)
+}
+
+type staticExtension struct {
+	kind  MessageKind
+	attrs []Param
+}
+
+// StaticExtension attaches attrs to every message of kind.
+func StaticExtension(kind MessageKind, attrs ...Param) Extension {
+	return &staticExtension{kind: kind, attrs: attrs}
+}
+
+func (ext *staticExtension) Write(kind MessageKind) ([]Param, error) {
+	if kind != ext.kind {
+		return nil, nil
+	}
+	return ext.attrs, nil
+}
+
+func (ext *staticExtension) Read(MessageKind, *Params) error {
+	return nil
+}
+
+// WithExtension registers ext with the client.
+func (client *ClientScramAuth) WithExtension(ext Extension) *ClientScramAuth {
+	client.scramAuth.extensions = append(client.scramAuth.extensions, ext)
+	return client
+}
+
+// WithMandatoryExtension sends name as the m attribute of the client-first
+// message, which a server not supporting it must reject.
+func (client *ClientScramAuth) WithMandatoryExtension(name string) *ClientScramAuth {
+	client.scramAuth.mandatoryExt = name
+	return client
+}
+
+// Attrs returns the extension attributes of the given message once it was
+// sent or received.
+func (client *ClientScramAuth) Attrs(kind MessageKind) *Params {
+	return client.scramAuth.attrsOf(kind)
+}
+
+// WithExtension registers ext with the server.
+func (server *ServerScramAuth) WithExtension(ext Extension) *ServerScramAuth {
+	server.scramAuth.extensions = append(server.scramAuth.extensions, ext)
+	return server
+}
+
+// WithMandatoryExtensions lists the m attribute values the server accepts.
+// Any other value fails the challenge with ErrExtensionsNotSupported.
+func (server *ServerScramAuth) WithMandatoryExtensions(names ...string) *ServerScramAuth {
+	server.scramAuth.mandatoryExts = append(server.scramAuth.mandatoryExts, names...)
+	return server
+}
+
+// Attrs returns the extension attributes of the given message once it was
+// sent or received.
+func (server *ServerScramAuth) Attrs(kind MessageKind) *Params {
+	return server.scramAuth.attrsOf(kind)
+}
+
+func (sa *scramAuth) attrsOf(kind MessageKind) *Params {
+	if sa.attrs[kind] == nil {
+		return NewParams()
+	}
+	return sa.attrs[kind]
+}
+
+func (sa *scramAuth) supportsMandatoryExt(name string) bool {
+	for _, n := range sa.mandatoryExts {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// setAttrs keeps the extension attributes of a received message.
+func (sa *scramAuth) setAttrs(kind MessageKind, p *Params) {
+	sa.attrs[kind] = NewParamsWith(extensionsOf(kind.grammar(), p))
+}
+
+func (sa *scramAuth) setExtensionsUser() {
+	for _, ext := range sa.extensions {
+		if u, ok := ext.(UserExtension); ok {
+			u.SetUser(sa.username(), sa.gs2Header.Authzid)
+		}
+	}
+}
+
+func (sa *scramAuth) readExtensions(kind MessageKind) error {
+	for _, ext := range sa.extensions {
+		if err := ext.Read(kind, sa.attrsOf(kind)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (sa *scramAuth) writeExtensions(kind MessageKind) ([]Param, error) {
+	var attrs []Param
+	for _, ext := range sa.extensions {
+		out, err := ext.Write(kind)
+		if err != nil {
+			return nil, err
+		}
+		attrs = append(attrs, out...)
+	}
+	sa.attrs[kind] = NewParamsWith(attrs)
+	return attrs, nil
+}
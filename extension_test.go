@@ -0,0 +1,87 @@
+package scramauth
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"testing"
+)
+
+type contributor struct {
+	data []byte
+}
+
+func (c *contributor) Write(MessageKind) ([]Param, error) { return nil, nil }
+func (c *contributor) Read(MessageKind, *Params) error    { return nil }
+func (c *contributor) AuthMessageData() []byte            { return c.data }
+
+func TestExtensionAttrs(t *testing.T) {
+	client := NewClientScramAuth(sha256.New, None, nil).
+		WithExtension(StaticExtension(MsgClientFirst, Param{Key: []byte{'x'}, Val: []byte("first")})).
+		WithExtension(StaticExtension(MsgClientFinal, Param{Key: []byte{'x'}, Val: []byte("final")}))
+	server := NewServerScramAuth(sha256.New, None, nil).
+		WithExtension(StaticExtension(MsgServerFirst, Param{Key: []byte{'y'}, Val: []byte("first")})).
+		WithExtension(StaticExtension(MsgServerFinal, Param{Key: []byte{'y'}, Val: []byte("final")}))
+	if err := runExchange(client, server, "123456", passwordKeys("12345678", 4096)); err != nil {
+		t.Fatalf("exchange error: %s", err.Error())
+	}
+	for _, c := range []struct {
+		attrs *Params
+		key   byte
+		want  string
+	}{
+		{server.Attrs(MsgClientFirst), 'x', "first"},
+		{server.Attrs(MsgClientFinal), 'x', "final"},
+		{client.Attrs(MsgServerFirst), 'y', "first"},
+		{client.Attrs(MsgServerFinal), 'y', "final"},
+		{client.Attrs(MsgClientFirst), 'x', "first"},
+	} {
+		if v, ok := c.attrs.Val([]byte{c.key}); !ok || string(v) != c.want {
+			t.Fatalf("unexpected %c: %s", c.key, v)
+		}
+	}
+	if server.Attrs(MsgClientFirst).Len() != 1 {
+		t.Fatalf("mandatory attributes reported as extensions")
+	}
+}
+
+func TestMandatoryExtension(t *testing.T) {
+	client := NewClientScramAuth(sha256.New, None, nil).WithMandatoryExtension("2fa")
+	var req bytes.Buffer
+	if err := client.WriteReqMsg("", "yang-zhong", &req); err != nil {
+		t.Fatalf("write req msg error: %s", err.Error())
+	}
+	server := NewServerScramAuth(sha256.New, None, nil)
+	err := server.WriteChallengeMsg(&req, func(username []byte) ([]byte, int, error) {
+		return []byte("12345678"), 4096, nil
+	}, &bytes.Buffer{})
+	if !errors.Is(err, ErrExtensionsNotSupported) {
+		t.Fatalf("expected ErrExtensionsNotSupported, got %v", err)
+	}
+	var final bytes.Buffer
+	if err := server.WriteErrorMsg(err, &final); err != nil {
+		t.Fatalf("write error msg error: %s", err.Error())
+	}
+	if final.String() != "e=extensions-not-supported" {
+		t.Fatalf("unexpected server-final message: %s", final.String())
+	}
+
+	client = NewClientScramAuth(sha256.New, None, nil).WithMandatoryExtension("2fa")
+	server = NewServerScramAuth(sha256.New, None, nil).WithMandatoryExtensions("2fa")
+	if err := runExchange(client, server, "123456", passwordKeys("12345678", 4096)); err != nil {
+		t.Fatalf("exchange error: %s", err.Error())
+	}
+}
+
+func TestAuthMessageContributor(t *testing.T) {
+	client := NewClientScramAuth(sha256.New, None, nil).WithExtension(&contributor{[]byte("bound")})
+	server := NewServerScramAuth(sha256.New, None, nil).WithExtension(&contributor{[]byte("bound")})
+	if err := runExchange(client, server, "123456", passwordKeys("12345678", 4096)); err != nil {
+		t.Fatalf("exchange error: %s", err.Error())
+	}
+	client = NewClientScramAuth(sha256.New, None, nil).WithExtension(&contributor{[]byte("bound")})
+	server = NewServerScramAuth(sha256.New, None, nil).WithExtension(&contributor{[]byte("other")})
+	if err := runExchange(client, server, "123456", passwordKeys("12345678", 4096)); !errors.Is(err, ErrInvalidProof) {
+		t.Fatalf("expected ErrInvalidProof, got %v", err)
+	}
+}
@@ -0,0 +1,123 @@
+package scramauth
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// DefaultMaxMessageSize bounds a single SCRAM message when no other limit
+// is configured. Real messages are a few hundred bytes.
+const DefaultMaxMessageSize = 4096
+
+// MessageTooLargeError is returned when a peer sends a message longer than
+// the configured limit.
+type MessageTooLargeError struct {
+	Limit int
+}
+
+func (e *MessageTooLargeError) Error() string {
+	return fmt.Sprintf("message too large: limit is %d bytes", e.Limit)
+}
+
+// Framing tells where a message ends on a stream, so a conversation can
+// read exactly one message off a connection that is never closed.
+type Framing interface {
+	// ReadMessage reads one message of at most max bytes.
+	ReadMessage(r io.Reader, max int) ([]byte, error)
+	WriteMessage(w io.Writer, msg []byte) error
+}
+
+// EOFFraming takes everything up to EOF as the message. It suits
+// transports that hand each message over as its own reader, like the text
+// of an XMPP element, and is the default.
+type EOFFraming struct{}
+
+func (EOFFraming) ReadMessage(r io.Reader, max int) ([]byte, error) {
+	msg, err := io.ReadAll(io.LimitReader(r, int64(max)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(msg) > max {
+		return nil, &MessageTooLargeError{Limit: max}
+	}
+	return msg, nil
+}
+
+func (EOFFraming) WriteMessage(w io.Writer, msg []byte) error {
+	return FullWrite(w, msg)
+}
+
+// DelimiterFraming ends each message with Delim, e.g. '\n' for line based
+// transports. Without an io.ByteReader it reads one byte at a time so it
+// never consumes past the delimiter; wrap connections in a bufio.Reader.
+type DelimiterFraming struct {
+	Delim byte
+}
+
+func (framing DelimiterFraming) ReadMessage(r io.Reader, max int) ([]byte, error) {
+	br, ok := r.(io.ByteReader)
+	if !ok {
+		br = byteReader{r}
+	}
+	var msg []byte
+	for {
+		b, err := br.ReadByte()
+		if err != nil {
+			if errors.Is(err, io.EOF) && len(msg) > 0 {
+				return nil, io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		if b == framing.Delim {
+			return msg, nil
+		}
+		if len(msg) == max {
+			return nil, &MessageTooLargeError{Limit: max}
+		}
+		msg = append(msg, b)
+	}
+}
+
+func (framing DelimiterFraming) WriteMessage(w io.Writer, msg []byte) error {
+	return FullWrite(w, append(append([]byte{}, msg...), framing.Delim))
+}
+
+// LengthPrefixFraming prefixes each message with its length as a 4 byte
+// big endian integer.
+type LengthPrefixFraming struct{}
+
+func (LengthPrefixFraming) ReadMessage(r io.Reader, max int) ([]byte, error) {
+	var prefix [4]byte
+	if _, err := io.ReadFull(r, prefix[:]); err != nil {
+		return nil, err
+	}
+	l := binary.BigEndian.Uint32(prefix[:])
+	if uint64(l) > uint64(max) {
+		return nil, &MessageTooLargeError{Limit: max}
+	}
+	msg := make([]byte, l)
+	if _, err := io.ReadFull(r, msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+func (LengthPrefixFraming) WriteMessage(w io.Writer, msg []byte) error {
+	out := make([]byte, 4, 4+len(msg))
+	binary.BigEndian.PutUint32(out, uint32(len(msg)))
+	return FullWrite(w, append(out, msg...))
+}
+
+type byteReader struct {
+	r io.Reader
+}
+
+func (br byteReader) ReadByte() (byte, error) {
+	var b [1]byte
+	if _, err := io.ReadFull(br.r, b[:]); err != nil {
+		return 0, err
+	}
+	return b[0], nil
+}
@@ -0,0 +1,106 @@
+package scramauth
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestDelimiterFraming(t *testing.T) {
+	r := strings.NewReader("n,,n=user,r=abc\nv=xyz\n")
+	framing := DelimiterFraming{Delim: '\n'}
+	msg, err := framing.ReadMessage(r, 64)
+	if err != nil || string(msg) != "n,,n=user,r=abc" {
+		t.Fatalf("unexpected message %q: %v", msg, err)
+	}
+	if rest, _ := io.ReadAll(r); string(rest) != "v=xyz\n" {
+		t.Fatalf("framing consumed past the delimiter: %q", rest)
+	}
+	if _, err := framing.ReadMessage(strings.NewReader("abc"), 64); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("expected unexpected EOF, got %v", err)
+	}
+	var tl *MessageTooLargeError
+	if _, err := framing.ReadMessage(bufio.NewReader(strings.NewReader(strings.Repeat("a", 65)+"\n")), 64); !errors.As(err, &tl) {
+		t.Fatalf("expected MessageTooLargeError, got %v", err)
+	}
+}
+
+func TestLengthPrefixFraming(t *testing.T) {
+	var buf bytes.Buffer
+	framing := LengthPrefixFraming{}
+	if err := framing.WriteMessage(&buf, []byte("r=abc,s=def,i=4096")); err != nil {
+		t.Fatalf("write message error: %s", err.Error())
+	}
+	if err := framing.WriteMessage(&buf, []byte("v=xyz")); err != nil {
+		t.Fatalf("write message error: %s", err.Error())
+	}
+	for _, want := range []string{"r=abc,s=def,i=4096", "v=xyz"} {
+		msg, err := framing.ReadMessage(&buf, 64)
+		if err != nil || string(msg) != want {
+			t.Fatalf("unexpected message %q: %v", msg, err)
+		}
+	}
+	var tl *MessageTooLargeError
+	if _, err := framing.ReadMessage(bytes.NewReader([]byte{0xff, 0xff, 0xff, 0xff}), 64); !errors.As(err, &tl) || tl.Limit != 64 {
+		t.Fatalf("expected MessageTooLargeError, got %v", err)
+	}
+}
+
+func TestEOFFramingTooLarge(t *testing.T) {
+	var tl *MessageTooLargeError
+	if _, err := (EOFFraming{}).ReadMessage(strings.NewReader(strings.Repeat("a", 65)), 64); !errors.As(err, &tl) {
+		t.Fatalf("expected MessageTooLargeError, got %v", err)
+	}
+	if err := NewEncoding().WithMaxSize(64).Decode(strings.NewReader(strings.Repeat("a", 65)), NewParams()); !errors.As(err, &tl) {
+		t.Fatalf("expected MessageTooLargeError from Encoding, got %v", err)
+	}
+	server := NewServerScramAuth(sha256.New, None, nil)
+	err := server.WriteChallengeMsg(strings.NewReader(strings.Repeat("a", DefaultMaxMessageSize+1)), func(username []byte) ([]byte, int, error) {
+		return []byte("12345678"), 4096, nil
+	}, &bytes.Buffer{})
+	if !errors.As(err, &tl) {
+		t.Fatalf("expected MessageTooLargeError from server, got %v", err)
+	}
+}
+
+func TestAuthOverConnection(t *testing.T) {
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+	defer serverConn.Close()
+
+	done := make(chan error, 1)
+	go func() {
+		server := NewServerScramAuth(sha256.New, None, nil).WithFraming(LengthPrefixFraming{}, 512)
+		saltedPassword := server.SaltedPassword([]byte("123456"), []byte("12345678"), 4096)
+		if err := server.WriteChallengeMsg(serverConn, func(username []byte) ([]byte, int, error) {
+			return []byte("12345678"), 4096, nil
+		}, serverConn); err != nil {
+			done <- err
+			return
+		}
+		if err := server.Verify(serverConn, saltedPassword); err != nil {
+			done <- err
+			return
+		}
+		done <- server.WriteSignatureMsg(nil, saltedPassword, serverConn)
+	}()
+
+	client := NewClientScramAuth(sha256.New, None, nil).WithFraming(LengthPrefixFraming{}, 512)
+	if err := client.WriteReqMsg("", "yang-zhong", clientConn); err != nil {
+		t.Fatalf("write req msg error: %s", err.Error())
+	}
+	if err := client.WriteResMsg(clientConn, "123456", clientConn); err != nil {
+		t.Fatalf("client response error: %s", err.Error())
+	}
+	if err := client.Verify(clientConn); err != nil {
+		t.Fatalf("client verify error: %s", err.Error())
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("server error: %s", err.Error())
+	}
+}
@@ -0,0 +1,163 @@
+package scramauth
+
+import (
+	"bytes"
+	"testing"
+)
+
+// The seeds below are hand-written, one list per kind of message, and
+// every target also gets malformedSeeds. The PostgreSQL and Kafka ones are
+// modelled on what those clients send, not captured from them. The RFC
+// 5802 and RFC 7677 exchanges are the seed files in testdata/fuzz, each
+// target holding the messages of the kind it parses.
+
+// clientFirstSeeds cover channel binding, authzids and the empty username
+// PostgreSQL sends, the tokenauth extension of Kafka and the mandatory
+// extension and nonstd flags.
+var clientFirstSeeds = []string{
+	"n,,n=,r=6K4cK1+sDF3tGMuDXBfbGtCD",
+	"p=tls-server-end-point,,n=,r=9Lz6E7PxVYoRz4yn3NlwAL0h",
+	"y,,n=,r=9Lz6E7PxVYoRz4yn3NlwAL0h",
+	"n,,n=alice,r=2kRpTcHEFyoG+UgDEpRBqVXT,tokenauth=true",
+	"n,a=juliet@capulet.lit,n=juliet,r=oMsTAAwAAAAMAAAANP0TAAAAAABPU0AA",
+	"n,,m=ext,n=user,r=abc",
+	"F,p=tls-unique,a=admin,n=user,r=abc",
+}
+
+// serverFirstSeeds carry the KDF and second factor attributes of this
+// library.
+var serverFirstSeeds = []string{
+	"r=abcdef,s=MTIzNDU2Nzg=,i=4096,k=argon2id;m=65536;p=4,t=totp",
+}
+
+var clientFinalSeeds = []string{
+	"c=cD10bHMtdW5pcXVlLCwAAQIDBAUGBwgJCgs=,r=abc,t=123456,p=dg==",
+}
+
+var serverFinalSeeds = []string{
+	"e=invalid-proof",
+	"e=other-error",
+}
+
+var malformedSeeds = []string{
+	"",
+	",",
+	"=",
+	"a=b=c,,d",
+}
+
+func addSeeds(f *testing.F, seeds ...[]string) {
+	for _, list := range append(seeds, malformedSeeds) {
+		for _, c := range list {
+			f.Add([]byte(c))
+		}
+	}
+}
+
+func FuzzEncodingRoundTrip(f *testing.F) {
+	addSeeds(f, serverFirstSeeds, clientFinalSeeds, serverFinalSeeds, []string{"n=us=2Cer=3D"})
+	f.Fuzz(func(t *testing.T, msg []byte) {
+		for _, encoding := range []*Encoding{NewEncoding(), NewStrictEncoding(Grammar{}), NewStrictEncoding(ClientFinalGrammar)} {
+			p := NewParams()
+			if err := encoding.Decode(bytes.NewReader(msg), p); err != nil {
+				continue
+			}
+			var buf bytes.Buffer
+			if err := encoding.Encode(&buf, p); err != nil {
+				t.Fatalf("%q: encode error: %s", msg, err.Error())
+			}
+			again := NewParams()
+			if err := encoding.Decode(bytes.NewReader(buf.Bytes()), again); err != nil {
+				t.Fatalf("%q: encoded as %q, which doesn't decode: %s", msg, buf.Bytes(), err.Error())
+			}
+			if !equalParams(p, again) {
+				t.Fatalf("%q: encoded as %q, which decodes differently", msg, buf.Bytes())
+			}
+		}
+	})
+}
+
+func equalParams(a, b *Params) bool {
+	if a.Len() != b.Len() {
+		return false
+	}
+	for i, p := range a.All() {
+		q := b.All()[i]
+		if !bytes.Equal(p.Key, q.Key) || !bytes.Equal(p.Val, q.Val) {
+			return false
+		}
+	}
+	return true
+}
+
+func FuzzGs2HeaderDecode(f *testing.F) {
+	addSeeds(f, clientFirstSeeds)
+	f.Fuzz(func(t *testing.T, msg []byte) {
+		var header Gs2Header
+		if err := header.Decode(bytes.NewReader(msg)); err != nil {
+			return
+		}
+		var buf bytes.Buffer
+		if err := header.Encode(&buf); err != nil {
+			t.Fatalf("%q: encode error: %s", msg, err.Error())
+		}
+		var again Gs2Header
+		if err := again.Decode(bytes.NewReader(buf.Bytes())); err != nil {
+			t.Fatalf("%q: encoded as %q, which doesn't decode: %s", msg, buf.Bytes(), err.Error())
+		}
+		if again.NonStd != header.NonStd || again.CB != header.CB || !bytes.Equal(again.Authzid, header.Authzid) || !equalParams(again.Params, header.Params) {
+			t.Fatalf("%q: encoded as %q, which decodes differently", msg, buf.Bytes())
+		}
+	})
+}
+
+func FuzzRsi(f *testing.F) {
+	addSeeds(f, serverFirstSeeds)
+	f.Fuzz(func(t *testing.T, msg []byte) {
+		sa := &scramAuth{hashBuild: HashBuild(SCRAM_SHA_256)}
+		r, s, i, p, err := sa.rsi(msg)
+		if err != nil {
+			return
+		}
+		if len(r) == 0 || s == nil || i < 1 || i > DefaultMaxIterations || p == nil {
+			t.Fatalf("%q: accepted as r=%q s=%q i=%d", msg, r, s, i)
+		}
+	})
+}
+
+func FuzzServerClientFirst(f *testing.F) {
+	addSeeds(f, clientFirstSeeds)
+	v := conformanceVectors[1]
+	f.Fuzz(func(t *testing.T, msg []byte) {
+		server := v.server()
+		var out bytes.Buffer
+		err := server.WriteChallengeMsg(bytes.NewReader(msg), func(username []byte) ([]byte, int, error) {
+			return []byte("12345678"), 4096, nil
+		}, &out)
+		if err != nil {
+			if out.Len() != 0 {
+				t.Fatalf("%q: server-first written on error %s", msg, err.Error())
+			}
+			return
+		}
+		if err := NewStrictEncoding(ServerFirstGrammar).Parse(out.Bytes(), NewParams()); err != nil {
+			t.Fatalf("%q: invalid server-first %q: %s", msg, out.Bytes(), err.Error())
+		}
+	})
+}
+
+func FuzzServerClientFinal(f *testing.F) {
+	addSeeds(f, clientFinalSeeds)
+	v := conformanceVectors[1]
+	salt, iter, _ := v.findSaltIter([]byte(v.username))
+	saltedPassword := v.server().SaltedPassword([]byte("pencil"), salt, iter)
+	f.Fuzz(func(t *testing.T, msg []byte) {
+		server := v.server()
+		if err := server.WriteChallengeMsg(bytes.NewBufferString(v.clientFirst), v.findSaltIter, &bytes.Buffer{}); err != nil {
+			t.Fatalf("write challenge msg error: %s", err.Error())
+		}
+		if err := server.Verify(bytes.NewReader(msg), saltedPassword); err == nil && string(msg) != v.clientFinal {
+			t.Fatalf("%q: accepted in place of %q", msg, v.clientFinal)
+		}
+	})
+}
@@ -1,7 +1,10 @@
 module github.com/yang-zzhong/scram-auth
 
-go 1.17
+go 1.18
 
-require golang.org/x/crypto v0.0.0-20210817164053-32db794688a5
+require (
+	golang.org/x/crypto v0.0.0-20210817164053-32db794688a5
+	golang.org/x/term v0.0.0-20210503060354-a79de5458b56
+)
 
 require golang.org/x/sys v0.0.0-20210615035016-665e8c7367d1 // indirect
@@ -2,7 +2,6 @@ package scramauth
 
 import (
 	"bytes"
-	"encoding/base64"
 	"errors"
 	"io"
 )
@@ -12,7 +11,7 @@ type CB string
 const (
 	TlsUnique          = CB("tls-unique")
 	TlsServerEndPoint  = CB("tls-server-end-point")
-	TlsUniqueForTelnet = CB("tls-unqiue-for-telnet")
+	TlsUniqueForTelnet = CB("tls-unique-for-telnet")
 	None               = CB("none")
 	Unset              = CB("unset")
 )
@@ -55,58 +54,115 @@ const (
 //
 //	;; The GS2 header is gs2-header.
 type Gs2Header struct {
+	// NonStd is the gs2-nonstd-flag "F" sent by GS2 wrapped mechanisms.
+	NonStd  bool
 	CB      CB
 	Authzid []byte
 	Params  *Params
+
+	// supportsCB records the "y" flag: the client supports channel binding
+	// but thinks the server doesn't.
+	supportsCB bool
 }
 
-func (header *Gs2Header) Encode(w io.Writer) error {
-	p := NewParams()
-	switch header.CB {
-	case None:
-		p.Append(Param{Key: []byte{'n'}})
-	case TlsUnique:
-		p.Append(Param{Key: []byte{'p'}, Val: []byte(TlsUnique)})
+// header returns the gs2-header.
+func (header *Gs2Header) header() []byte {
+	if header.NonStd {
+		return append([]byte("F,"), header.cbindHeader()...)
+	}
+	return header.cbindHeader()
+}
+
+// cbindHeader returns the gs2-header without the gs2-nonstd-flag, which the
+// c attribute of the client-final message repeats.
+func (header *Gs2Header) cbindHeader() []byte {
+	var out []byte
+	switch {
+	case header.CB != None && header.CB != "":
+		out = append(out, "p="...)
+		out = append(out, header.CB...)
+	case header.supportsCB:
+		out = append(out, 'y')
+	default:
+		out = append(out, 'n')
 	}
+	out = append(out, ',')
 	if len(header.Authzid) != 0 {
-		p.Append(Param{Key: []byte{'a'}, Val: []byte(header.Authzid)})
-	} else {
-		p.Append(Param{})
+		out = append(out, "a="...)
+		out = append(out, escapeSaslname(header.Authzid)...)
 	}
-	p.Append(header.Params.All()...)
+	return append(out, ',')
+}
+
+// Encode writes the client-first message, the gs2-header followed by
+// Params as the client-first-message-bare.
+func (header *Gs2Header) Encode(w io.Writer) error {
 	var buf bytes.Buffer
-	if err := NewEncoding().Encode(&buf, p); err != nil {
+	buf.Write(header.header())
+	if err := NewEncoding().Encode(&buf, header.Params); err != nil {
 		return err
 	}
-	return FullWrite(w, []byte(base64.StdEncoding.EncodeToString(buf.Bytes())))
+	return FullWrite(w, buf.Bytes())
 }
 
+// Decode reads a client-first message up to EOF, at most
+// DefaultMaxMessageSize bytes.
 func (header *Gs2Header) Decode(r io.Reader) error {
-	var buf bytes.Buffer
-	if _, err := io.Copy(&buf, base64.NewDecoder(base64.StdEncoding, r)); err != nil {
-		return err
+	return header.DecodeMax(r, DefaultMaxMessageSize)
+}
+
+// DecodeMax is Decode reading at most max bytes, DefaultMaxMessageSize
+// when max isn't positive, e.g. the limit the server was configured with.
+func (header *Gs2Header) DecodeMax(r io.Reader, max int) error {
+	if max <= 0 {
+		max = DefaultMaxMessageSize
 	}
-	params := NewParams()
-	if err := NewEncoding().Decode(&buf, params); err != nil {
+	msg, err := EOFFraming{}.ReadMessage(r, max)
+	if err != nil {
 		return err
 	}
-	p := params.All()
-	if len(p) < 2 {
-		return errors.New("invalid gs2 header")
+	_, err = header.decodeWith(msg, NewEncoding().WithMaxSize(max))
+	return err
+}
+
+// decodeWith decodes the gs2 header of a client-first message and parses
+// the client-first-message-bare after it with encoding, returning the bare
+// message as received.
+func (header *Gs2Header) decodeWith(msg []byte, encoding *Encoding) ([]byte, error) {
+	header.NonStd = bytes.HasPrefix(msg, []byte("F,"))
+	if header.NonStd {
+		msg = msg[2:]
+	}
+	p := bytes.SplitN(msg, []byte{','}, 3)
+	if len(p) < 3 {
+		return nil, errors.New("invalid gs2 header")
 	}
-	switch string(p[0].Key) {
-	case "p":
-		header.CB = CB(string(p[0].Val))
-	case "n", "y":
+	header.supportsCB = false
+	switch {
+	case bytes.HasPrefix(p[0], []byte("p=")) && len(p[0]) > 2:
+		header.CB = CB(string(p[0][2:]))
+	case string(p[0]) == "n":
+		header.CB = None
+	case string(p[0]) == "y":
 		header.CB = None
+		header.supportsCB = true
 	default:
-		return errors.New("invalid gs2 headder")
+		return nil, errors.New("invalid gs2 header")
+	}
+	switch {
+	case bytes.HasPrefix(p[1], []byte("a=")) && len(p[1]) > 2:
+		if reason := validSaslname(p[1][2:]); reason != "" {
+			return nil, &AttributeError{Attr: "a", Reason: reason}
+		}
+		header.Authzid = unescapeSaslname(p[1][2:])
+	case len(p[1]) == 0:
+		header.Authzid = nil
+	default:
+		return nil, errors.New("invalid gs2 header")
 	}
-	// if !bytes.Equal(p[1].Key, []byte{'a'}) {
-	// 	return errors.New("invalid gs2 headder")
-	// }
-	header.Authzid = p[1].Val
 	header.Params = NewParams()
-	header.Params.Append(p[2:]...)
-	return nil
+	if err := encoding.Parse(p[2], header.Params); err != nil {
+		return nil, err
+	}
+	return p[2], nil
 }
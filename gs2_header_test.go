@@ -2,8 +2,9 @@ package scramauth
 
 import (
 	"bytes"
-	"encoding/base64"
+	"errors"
 	"fmt"
+	"strings"
 	"testing"
 )
 
@@ -19,7 +20,7 @@ func TestEncode(t *testing.T) {
 	if err := header.Encode(&buf); err != nil {
 		t.Fatalf("encoding gs2 header error: %s", err.Error())
 	}
-	res := base64.StdEncoding.EncodeToString([]byte("p=tls-unique,a=123456,n=helloworld,r=123456"))
+	res := "p=tls-unique,a=123456,n=helloworld,r=123456"
 	if buf.String() != res {
 		fmt.Printf("%s - %s\n", buf.String(), res)
 		t.Fatalf("encode gs2 header error")
@@ -27,7 +28,7 @@ func TestEncode(t *testing.T) {
 }
 
 func TestEncode_noAuthId(t *testing.T) {
-	str := base64.StdEncoding.EncodeToString([]byte("p=tls-unique,,n=helloworld,r=123456"))
+	str := "p=tls-unique,,n=helloworld,r=123456"
 	header := Gs2Header{}
 	buf1 := bytes.NewBuffer([]byte(str))
 	if err := header.Decode(buf1); err != nil {
@@ -46,7 +47,7 @@ func TestEncode_noAuthId(t *testing.T) {
 }
 
 func TestDecode(t *testing.T) {
-	str := "cD10bHMtdW5pcXVlLGE9MTIzNDU2LG49aGVsbG93b3JsZCxyPTEyMzQ1Ng=="
+	str := "p=tls-unique,a=123456,n=helloworld,r=123456"
 	header := Gs2Header{}
 	buf1 := bytes.NewBuffer([]byte(str))
 	if err := header.Decode(buf1); err != nil {
@@ -64,3 +65,66 @@ func TestDecode(t *testing.T) {
 		t.Fatalf("decode gs2 header error")
 	}
 }
+
+func TestNonStdFlag(t *testing.T) {
+	header := Gs2Header{}
+	if err := header.Decode(bytes.NewBufferString("F,p=tls-unique,a=admin,n=user,r=abc")); err != nil {
+		t.Fatalf("decode error: %s", err.Error())
+	}
+	if !header.NonStd || header.CB != TlsUnique || string(header.Authzid) != "admin" {
+		t.Fatalf("unexpected header %+v", header)
+	}
+	if string(header.cbindHeader()) != "p=tls-unique,a=admin," {
+		t.Fatalf("unexpected channel binding header %q", header.cbindHeader())
+	}
+	var buf bytes.Buffer
+	if err := header.Encode(&buf); err != nil {
+		t.Fatalf("encode error: %s", err.Error())
+	}
+	if buf.String() != "F,p=tls-unique,a=admin,n=user,r=abc" {
+		t.Fatalf("unexpected encoding %s", buf.String())
+	}
+	for _, msg := range []string{"F,,n=user,r=abc", "F,F,n,,n=user,r=abc", "f,n,,n=user,r=abc"} {
+		if err := (&Gs2Header{}).Decode(bytes.NewBufferString(msg)); err == nil {
+			t.Fatalf("%s: expected error", msg)
+		}
+	}
+}
+
+// TestServerStripsNonStdFlag replays the RFC 7677 exchange with the flag:
+// neither the client-first-message-bare nor the c attribute include it, so
+// the published proof still verifies.
+func TestServerStripsNonStdFlag(t *testing.T) {
+	v := conformanceVectors[1]
+	server := v.server()
+	var challenge bytes.Buffer
+	if err := server.WriteChallengeMsg(bytes.NewBufferString("F,"+v.clientFirst), v.findSaltIter, &challenge); err != nil {
+		t.Fatalf("write challenge msg error: %s", err.Error())
+	}
+	salt, iter, _ := v.findSaltIter([]byte(v.username))
+	if err := server.Verify(bytes.NewBufferString(v.clientFinal), server.SaltedPassword([]byte("pencil"), salt, iter)); err != nil {
+		t.Fatalf("verify error: %s", err.Error())
+	}
+	client := v.client().WithNonStdFlag()
+	var req bytes.Buffer
+	if err := client.WriteReqMsg("", v.username, &req); err != nil {
+		t.Fatalf("write req msg error: %s", err.Error())
+	}
+	if req.String() != "F,"+v.clientFirst {
+		t.Fatalf("unexpected client-first %s", req.String())
+	}
+}
+
+func TestDecodeMax(t *testing.T) {
+	msg := "n,,n=user,r=" + strings.Repeat("a", DefaultMaxMessageSize)
+	var tl *MessageTooLargeError
+	if err := (&Gs2Header{}).Decode(bytes.NewBufferString(msg)); !errors.As(err, &tl) {
+		t.Fatalf("expected MessageTooLargeError, got %v", err)
+	}
+	if err := (&Gs2Header{}).DecodeMax(bytes.NewBufferString(msg), 2*DefaultMaxMessageSize); err != nil {
+		t.Fatalf("decode error: %s", err.Error())
+	}
+	if err := (&Gs2Header{}).DecodeMax(bytes.NewBufferString("n,,n=user,r=abc"), 8); !errors.As(err, &tl) || tl.Limit != 8 {
+		t.Fatalf("expected MessageTooLargeError with limit 8, got %v", err)
+	}
+}
@@ -1,17 +1,16 @@
 package scramauth
 
 import (
+	"context"
 	"hash"
 	"io"
-
-	"golang.org/x/crypto/pbkdf2"
 )
 
 func FullWrite(w io.Writer, b []byte) error {
 	total := len(b)
 	wrote := 0
 	for wrote < total {
-		l, err := w.Write(b)
+		l, err := w.Write(b[wrote:])
 		if err != nil {
 			return err
 		}
@@ -21,5 +20,16 @@ func FullWrite(w io.Writer, b []byte) error {
 }
 
 func SaltPassword(h func() hash.Hash, password, salt []byte, iter int) []byte {
-	return pbkdf2.Key(password, salt, iter, h().Size(), h)
+	saltedPassword, _ := SaltPasswordContext(context.Background(), h, password, salt, iter)
+	return saltedPassword
+}
+
+func SaltPasswordContext(ctx context.Context, h func() hash.Hash, password, salt []byte, iter int) ([]byte, error) {
+	return pbkdf2Key(ctx, password, salt, iter, h().Size(), h)
+}
+
+func zero(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
 }
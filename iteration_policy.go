@@ -0,0 +1,95 @@
+package scramauth
+
+import "fmt"
+
+// DefaultMaxIterations is the highest iteration count a client accepts
+// when its policy doesn't set one, so a server can't make it burn CPU.
+const DefaultMaxIterations = 10000000
+
+// minIterations holds the minimum iteration counts recommended for each
+// mechanism: RFC 5802 and RFC 7677 ask for at least 4096, the SHA-512 and
+// SHA3 drafts for at least 10000.
+var minIterations = map[string]int{
+	SCRAM_SHA_1:         4096,
+	SCRAM_SHA_1_PLUS:    4096,
+	SCRAM_SHA_224:       4096,
+	SCRAM_SHA_224_PLUS:  4096,
+	SCRAM_SHA_256:       4096,
+	SCRAM_SHA_256_PLUS:  4096,
+	SCRAM_SHA_384:       10000,
+	SCRAM_SHA_384_PLUS:  10000,
+	SCRAM_SHA_512:       10000,
+	SCRAM_SHA_512_PLUS:  10000,
+	SCRAM_SHA3_224:      10000,
+	SCRAM_SHA3_224_PLUS: 10000,
+	SCRAM_SHA3_256:      10000,
+	SCRAM_SHA3_256_PLUS: 10000,
+	SCRAM_SHA3_384:      10000,
+	SCRAM_SHA3_384_PLUS: 10000,
+	SCRAM_SHA3_512:      10000,
+	SCRAM_SHA3_512_PLUS: 10000,
+}
+
+// kdfIterations bounds the i attribute of the memory-hard mechanisms,
+// where it is the Argon2id time cost or the scrypt cost parameter N.
+var kdfIterations = map[string]IterationPolicy{
+	X_SCRAM_ARGON2ID_SHA_256:      {Min: 1, Max: maxArgon2Time},
+	X_SCRAM_ARGON2ID_SHA_256_PLUS: {Min: 1, Max: maxArgon2Time},
+	X_SCRAM_ARGON2ID_SHA_512:      {Min: 1, Max: maxArgon2Time},
+	X_SCRAM_ARGON2ID_SHA_512_PLUS: {Min: 1, Max: maxArgon2Time},
+	X_SCRAM_SCRYPT_SHA_256:        {Min: 1 << 14, Max: maxScryptN},
+	X_SCRAM_SCRYPT_SHA_256_PLUS:   {Min: 1 << 14, Max: maxScryptN},
+	X_SCRAM_SCRYPT_SHA_512:        {Min: 1 << 14, Max: maxScryptN},
+	X_SCRAM_SCRYPT_SHA_512_PLUS:   {Min: 1 << 14, Max: maxScryptN},
+}
+
+// IterationPolicy bounds the iteration count a client accepts in the
+// server-first message. A zero Min accepts any positive count and a zero
+// Max falls back to DefaultMaxIterations.
+type IterationPolicy struct {
+	Min int
+	Max int
+}
+
+// IterationPolicyFor returns the recommended policy for mechanism.
+func IterationPolicyFor(mechanism string) IterationPolicy {
+	if policy, ok := kdfIterations[mechanism]; ok {
+		return policy
+	}
+	min, ok := minIterations[mechanism]
+	if !ok {
+		min = 4096
+	}
+	return IterationPolicy{Min: min, Max: DefaultMaxIterations}
+}
+
+func (policy IterationPolicy) bounds() (min, max int) {
+	min, max = policy.Min, policy.Max
+	if min < 1 {
+		min = 1
+	}
+	if max <= 0 {
+		max = DefaultMaxIterations
+	}
+	return
+}
+
+func (policy IterationPolicy) check(iter int) error {
+	min, max := policy.bounds()
+	if iter < min || iter > max {
+		return &IterationCountError{Iter: iter, Min: min, Max: max}
+	}
+	return nil
+}
+
+// IterationCountError is returned by the client when the server asks for
+// an iteration count outside of its policy.
+type IterationCountError struct {
+	Iter int
+	Min  int
+	Max  int
+}
+
+func (e *IterationCountError) Error() string {
+	return fmt.Sprintf("iteration count %d out of bounds [%d, %d]", e.Iter, e.Min, e.Max)
+}
@@ -0,0 +1,72 @@
+package scramauth
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestIterationPolicyFor(t *testing.T) {
+	if p := IterationPolicyFor(SCRAM_SHA_256); p.Min != 4096 || p.Max != DefaultMaxIterations {
+		t.Fatalf("unexpected policy for %s: %+v", SCRAM_SHA_256, p)
+	}
+	if p := IterationPolicyFor(SCRAM_SHA_512_PLUS); p.Min != 10000 {
+		t.Fatalf("unexpected policy for %s: %+v", SCRAM_SHA_512_PLUS, p)
+	}
+}
+
+func TestClientRejectsIterationCount(t *testing.T) {
+	for _, iter := range []int{1, 4095, DefaultMaxIterations + 1} {
+		auth := NewClientScramAuth(sha256.New, None, nil).
+			WithIterationPolicy(IterationPolicyFor(SCRAM_SHA_256))
+		var req bytes.Buffer
+		if err := auth.WriteReqMsg("", "yang-zhong", &req); err != nil {
+			t.Fatalf("write req msg error: %s", err.Error())
+		}
+		challenge := fmt.Sprintf("r=abcdef,s=12345678,i=%d", iter)
+		var res bytes.Buffer
+		err := auth.WriteResMsg(bytes.NewBufferString(challenge), "123456", &res)
+		var ie *IterationCountError
+		if !errors.As(err, &ie) {
+			t.Fatalf("iteration count %d: expected IterationCountError, got %v", iter, err)
+		}
+		if ie.Iter != iter || ie.Min != 4096 {
+			t.Fatalf("unexpected error: %s", ie.Error())
+		}
+		if res.Len() != 0 {
+			t.Fatalf("client response written for iteration count %d", iter)
+		}
+	}
+}
+
+func TestClientDefaultIterationPolicy(t *testing.T) {
+	for _, iter := range []int{1, 4095, DefaultMaxIterations + 1} {
+		auth := NewClientScramAuth(sha256.New, None, nil)
+		var req bytes.Buffer
+		if err := auth.WriteReqMsg("", "yang-zhong", &req); err != nil {
+			t.Fatalf("write req msg error: %s", err.Error())
+		}
+		challenge := fmt.Sprintf("r=abcdef,s=12345678,i=%d", iter)
+		var ie *IterationCountError
+		if err := auth.WriteResMsg(bytes.NewBufferString(challenge), "123456", &bytes.Buffer{}); !errors.As(err, &ie) {
+			t.Fatalf("iteration count %d: expected IterationCountError, got %v", iter, err)
+		}
+	}
+}
+
+func TestClientRejectsMalformedIterationCount(t *testing.T) {
+	for _, iter := range []string{"-1", "0", "04096", "4096x"} {
+		auth := NewClientScramAuth(sha256.New, None, nil)
+		var req bytes.Buffer
+		if err := auth.WriteReqMsg("", "yang-zhong", &req); err != nil {
+			t.Fatalf("write req msg error: %s", err.Error())
+		}
+		challenge := "r=abcdef,s=12345678,i=" + iter
+		var ae *AttributeError
+		if err := auth.WriteResMsg(bytes.NewBufferString(challenge), "123456", &bytes.Buffer{}); !errors.As(err, &ae) {
+			t.Fatalf("iteration count %s: expected AttributeError, got %v", iter, err)
+		}
+	}
+}
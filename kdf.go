@@ -0,0 +1,245 @@
+package scramauth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/scrypt"
+)
+
+// Mechanisms deriving the SaltedPassword with a memory-hard KDF instead of
+// PBKDF2. They are private to this library, hence the X- prefix, so they
+// never collide with the standard SCRAM mechanisms.
+const (
+	X_SCRAM_ARGON2ID_SHA_256      = "X-SCRAM-ARGON2ID-SHA-256"
+	X_SCRAM_ARGON2ID_SHA_256_PLUS = "X-SCRAM-ARGON2ID-SHA-256-PLUS"
+	X_SCRAM_ARGON2ID_SHA_512      = "X-SCRAM-ARGON2ID-SHA-512"
+	X_SCRAM_ARGON2ID_SHA_512_PLUS = "X-SCRAM-ARGON2ID-SHA-512-PLUS"
+	X_SCRAM_SCRYPT_SHA_256        = "X-SCRAM-SCRYPT-SHA-256"
+	X_SCRAM_SCRYPT_SHA_256_PLUS   = "X-SCRAM-SCRYPT-SHA-256-PLUS"
+	X_SCRAM_SCRYPT_SHA_512        = "X-SCRAM-SCRYPT-SHA-512"
+	X_SCRAM_SCRYPT_SHA_512_PLUS   = "X-SCRAM-SCRYPT-SHA-512-PLUS"
+)
+
+// Hard limits on the cost a server can ask of a client, whatever its
+// iteration policy.
+const (
+	maxArgon2Time = 16
+	maxScryptN    = 1 << 20
+)
+
+// KDFAttr is the server-first extension attribute announcing the KDF and
+// its parameters other than the i attribute.
+const KDFAttr = 'k'
+
+// KDF derives the SaltedPassword. iter is the i attribute of the
+// server-first message: the iteration count of PBKDF2, the time cost of
+// Argon2id and the cost parameter N of scrypt.
+type KDF interface {
+	Key(ctx context.Context, password, salt []byte, iter, keyLen int) ([]byte, error)
+	// Attr is the value of the k attribute the server sends, "" for none.
+	Attr() string
+	// Negotiate returns the KDF to use for the k attribute and iteration
+	// count sent by the server. On the client the receiver sets the
+	// highest parameters accepted.
+	Negotiate(attr string, iter int) (KDF, error)
+}
+
+// KDFFor returns the KDF of mechanism with its default parameters.
+func KDFFor(mechanism string) KDF {
+	switch mechanism {
+	case X_SCRAM_ARGON2ID_SHA_256, X_SCRAM_ARGON2ID_SHA_256_PLUS, X_SCRAM_ARGON2ID_SHA_512, X_SCRAM_ARGON2ID_SHA_512_PLUS:
+		return &Argon2id{Memory: 64 * 1024, Threads: 4}
+	case X_SCRAM_SCRYPT_SHA_256, X_SCRAM_SCRYPT_SHA_256_PLUS, X_SCRAM_SCRYPT_SHA_512, X_SCRAM_SCRYPT_SHA_512_PLUS:
+		return &Scrypt{R: 8, P: 1}
+	}
+	return &PBKDF2{Hash: HashBuild(mechanism)}
+}
+
+// kdfOf returns the KDF of mechanism with the parameters of the k
+// attribute attr, the defaults when attr is empty. Unlike Negotiate it
+// doesn't bound the parameters, the server chose them.
+func kdfOf(mechanism, attr string) (KDF, error) {
+	kdf := KDFFor(mechanism)
+	if attr == "" {
+		return kdf, nil
+	}
+	switch kdf.(type) {
+	case *Argon2id:
+		params, err := kdfParams(attr, "argon2id", "m", "p")
+		if err != nil {
+			return nil, err
+		}
+		m, p := params["m"], params["p"]
+		if p < 1 || p > 255 || m < 8*p || m > 1<<32-1 {
+			return nil, fmt.Errorf("invalid argon2id parameters: %s", attr)
+		}
+		return &Argon2id{Memory: uint32(m), Threads: uint8(p)}, nil
+	case *Scrypt:
+		params, err := kdfParams(attr, "scrypt", "r", "p")
+		if err != nil {
+			return nil, err
+		}
+		if params["r"] < 1 || params["p"] < 1 {
+			return nil, fmt.Errorf("invalid scrypt parameters: %s", attr)
+		}
+		return &Scrypt{R: params["r"], P: params["p"]}, nil
+	}
+	return kdf.Negotiate(attr, 0)
+}
+
+// PBKDF2 is the KDF of the standard mechanisms, Hi() of RFC 5802.
+type PBKDF2 struct {
+	Hash func() hash.Hash
+}
+
+func (kdf *PBKDF2) Key(ctx context.Context, password, salt []byte, iter, keyLen int) ([]byte, error) {
+	return pbkdf2Key(ctx, password, salt, iter, keyLen, kdf.Hash)
+}
+
+func (kdf *PBKDF2) Attr() string {
+	return ""
+}
+
+// Negotiate accepts servers sending no k attribute, as standard servers
+// do, or one naming pbkdf2.
+func (kdf *PBKDF2) Negotiate(attr string, iter int) (KDF, error) {
+	if attr == "" {
+		return kdf, nil
+	}
+	if _, err := kdfParams(attr, "pbkdf2"); err != nil {
+		return nil, err
+	}
+	return kdf, nil
+}
+
+// Argon2id derives keys with Argon2id. Memory is in KiB.
+type Argon2id struct {
+	Memory  uint32
+	Threads uint8
+}
+
+// Key can't stop argon2 once started: ctx is checked before and after, so
+// a cancelled derivation still runs to the end but its key is dropped.
+func (kdf *Argon2id) Key(ctx context.Context, password, salt []byte, iter, keyLen int) ([]byte, error) {
+	if iter < 1 || kdf.Threads < 1 || kdf.Memory < 8*uint32(kdf.Threads) {
+		return nil, errors.New("invalid argon2id parameters")
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	key := argon2.IDKey(password, salt, uint32(iter), kdf.Memory, kdf.Threads, uint32(keyLen))
+	if err := ctx.Err(); err != nil {
+		zero(key)
+		return nil, err
+	}
+	return key, nil
+}
+
+func (kdf *Argon2id) Attr() string {
+	return fmt.Sprintf("argon2id;m=%d;p=%d", kdf.Memory, kdf.Threads)
+}
+
+func (kdf *Argon2id) Negotiate(attr string, iter int) (KDF, error) {
+	params, err := kdfParams(attr, "argon2id", "m", "p")
+	if err != nil {
+		return nil, err
+	}
+	m, p := params["m"], params["p"]
+	if m > int(kdf.Memory) || p > int(kdf.Threads) || p < 1 || m < 8*p || iter > maxArgon2Time {
+		return nil, fmt.Errorf("argon2id parameters out of bounds: %s", attr)
+	}
+	return &Argon2id{Memory: uint32(m), Threads: uint8(p)}, nil
+}
+
+// Scrypt derives keys with scrypt, taking N from the iteration count.
+type Scrypt struct {
+	R int
+	P int
+}
+
+// Key checks ctx before and after scrypt, which can't be stopped, like
+// Argon2id.Key.
+func (kdf *Scrypt) Key(ctx context.Context, password, salt []byte, iter, keyLen int) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	key, err := scrypt.Key(password, salt, iter, kdf.R, kdf.P, keyLen)
+	if err == nil && ctx.Err() != nil {
+		zero(key)
+		return nil, ctx.Err()
+	}
+	return key, err
+}
+
+func (kdf *Scrypt) Attr() string {
+	return fmt.Sprintf("scrypt;r=%d;p=%d", kdf.R, kdf.P)
+}
+
+func (kdf *Scrypt) Negotiate(attr string, iter int) (KDF, error) {
+	params, err := kdfParams(attr, "scrypt", "r", "p")
+	if err != nil {
+		return nil, err
+	}
+	r, p := params["r"], params["p"]
+	if r < 1 || p < 1 || r > kdf.R || p > kdf.P || iter < 2 || iter > maxScryptN || iter&(iter-1) != 0 {
+		return nil, fmt.Errorf("scrypt parameters out of bounds: %s", attr)
+	}
+	return &Scrypt{R: r, P: p}, nil
+}
+
+// kdfParams parses a k attribute of the form name;key=value;...
+func kdfParams(attr, name string, keys ...string) (map[string]int, error) {
+	fields := strings.Split(attr, ";")
+	if fields[0] != name || len(fields) != len(keys)+1 {
+		return nil, fmt.Errorf("expected %s parameters, got %q", name, attr)
+	}
+	params := map[string]int{}
+	for i, key := range keys {
+		kv := strings.SplitN(fields[i+1], "=", 2)
+		if len(kv) != 2 || kv[0] != key {
+			return nil, fmt.Errorf("expected %s parameters, got %q", name, attr)
+		}
+		v, err := strconv.Atoi(kv[1])
+		if err != nil || v < 0 {
+			return nil, fmt.Errorf("invalid %s parameter %s", name, key)
+		}
+		params[key] = v
+	}
+	return params, nil
+}
+
+// WithKDF sets the KDF deriving the SaltedPassword. Its parameters are the
+// highest the client accepts from the server.
+func (client *ClientScramAuth) WithKDF(kdf KDF) *ClientScramAuth {
+	client.scramAuth.kdf = kdf
+	return client
+}
+
+// WithKDF sets the KDF deriving the SaltedPassword, announced to clients
+// in the server-first message.
+func (server *ServerScramAuth) WithKDF(kdf KDF) *ServerScramAuth {
+	server.scramAuth.kdf = kdf
+	return server
+}
+
+func (sa *scramAuth) configuredKDF() KDF {
+	if sa.kdf == nil {
+		return &PBKDF2{Hash: sa.hashBuild}
+	}
+	return sa.kdf
+}
+
+// conversationKDF is the KDF negotiated with the server on the client, the
+// configured one on the server.
+func (sa *scramAuth) conversationKDF() KDF {
+	if sa.negotiatedKDF != nil {
+		return sa.negotiatedKDF
+	}
+	return sa.configuredKDF()
+}
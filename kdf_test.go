@@ -0,0 +1,102 @@
+package scramauth
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"testing"
+)
+
+func TestArgon2idExchange(t *testing.T) {
+	client := NewClientScramAuth(sha256.New, None, nil).WithKDF(&Argon2id{Memory: 1024, Threads: 2}).
+		WithIterationPolicy(IterationPolicyFor(X_SCRAM_ARGON2ID_SHA_256))
+	server := NewServerScramAuth(sha256.New, None, nil).WithKDF(&Argon2id{Memory: 256, Threads: 1})
+	if err := runExchange(client, server, "123456", passwordKeys("12345678", 2)); err != nil {
+		t.Fatalf("argon2id exchange error: %s", err.Error())
+	}
+	client = NewClientScramAuth(sha256.New, None, nil).WithKDF(&Argon2id{Memory: 1024, Threads: 2}).
+		WithIterationPolicy(IterationPolicyFor(X_SCRAM_ARGON2ID_SHA_256))
+	server = NewServerScramAuth(sha256.New, None, nil).WithKDF(&Argon2id{Memory: 256, Threads: 1})
+	if err := runExchange(client, server, "654321", passwordKeys("12345678", 2)); !errors.Is(err, ErrInvalidProof) {
+		t.Fatalf("expected ErrInvalidProof, got %v", err)
+	}
+}
+
+func TestScryptExchange(t *testing.T) {
+	client := NewClientScramAuth(sha256.New, None, nil).WithKDF(&Scrypt{R: 8, P: 1}).
+		WithIterationPolicy(IterationPolicyFor(X_SCRAM_SCRYPT_SHA_256))
+	server := NewServerScramAuth(sha256.New, None, nil).WithKDF(&Scrypt{R: 8, P: 1})
+	if err := runExchange(client, server, "123456", passwordKeys("12345678", 1<<14)); err != nil {
+		t.Fatalf("scrypt exchange error: %s", err.Error())
+	}
+}
+
+func TestKDFNegotiationRejected(t *testing.T) {
+	cases := []struct {
+		name   string
+		client KDF
+		server KDF
+		iter   int
+	}{
+		{"pbkdf2 client", nil, &Argon2id{Memory: 256, Threads: 1}, 4096},
+		{"pbkdf2 server", &Argon2id{Memory: 256, Threads: 1}, nil, 4096},
+		{"argon2id memory", &Argon2id{Memory: 256, Threads: 1}, &Argon2id{Memory: 512, Threads: 1}, 1},
+		{"argon2id threads", &Argon2id{Memory: 256, Threads: 1}, &Argon2id{Memory: 256, Threads: 2}, 1},
+		{"argon2id time", &Argon2id{Memory: 256, Threads: 1}, &Argon2id{Memory: 256, Threads: 1}, maxArgon2Time + 1},
+		{"scrypt N", &Scrypt{R: 8, P: 1}, &Scrypt{R: 8, P: 1}, 1000},
+		{"scrypt r", &Scrypt{R: 8, P: 1}, &Scrypt{R: 16, P: 1}, 1024},
+		{"kdf mismatch", &Scrypt{R: 8, P: 1}, &Argon2id{Memory: 256, Threads: 1}, 1024},
+	}
+	for _, c := range cases {
+		client := NewClientScramAuth(sha256.New, None, nil)
+		if c.client != nil {
+			client.WithKDF(c.client)
+		}
+		server := NewServerScramAuth(sha256.New, None, nil)
+		if c.server != nil {
+			server.WithKDF(c.server)
+		}
+		if err := runExchange(client, server, "123456", passwordKeys("12345678", c.iter)); err == nil {
+			t.Fatalf("%s: exchange succeeded", c.name)
+		}
+	}
+}
+
+func TestPBKDF2Negotiate(t *testing.T) {
+	kdf := &PBKDF2{Hash: sha256.New}
+	for _, attr := range []string{"", "pbkdf2"} {
+		if _, err := kdf.Negotiate(attr, 4096); err != nil {
+			t.Fatalf("k=%s rejected: %s", attr, err.Error())
+		}
+	}
+	for _, attr := range []string{"argon2id;m=256;p=1", "pbkdf2;c=1", "pbkdf"} {
+		if _, err := kdf.Negotiate(attr, 4096); err == nil {
+			t.Fatalf("k=%s accepted", attr)
+		}
+	}
+}
+
+func TestKDFCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for _, kdf := range []KDF{&Argon2id{Memory: 256, Threads: 1}, &Scrypt{R: 8, P: 1}} {
+		if key, err := kdf.Key(ctx, []byte("123456"), []byte("12345678"), 2, 32); !errors.Is(err, context.Canceled) || key != nil {
+			t.Fatalf("%s: expected context.Canceled, got %v", kdf.Attr(), err)
+		}
+	}
+}
+
+func TestKDFFor(t *testing.T) {
+	if _, ok := KDFFor(X_SCRAM_ARGON2ID_SHA_256).(*Argon2id); !ok {
+		t.Fatalf("expected Argon2id for %s", X_SCRAM_ARGON2ID_SHA_256)
+	}
+	if _, ok := KDFFor(X_SCRAM_SCRYPT_SHA_512_PLUS).(*Scrypt); !ok {
+		t.Fatalf("expected Scrypt for %s", X_SCRAM_SCRYPT_SHA_512_PLUS)
+	}
+	if _, ok := KDFFor(SCRAM_SHA_256).(*PBKDF2); !ok {
+		t.Fatalf("expected PBKDF2 for %s", SCRAM_SHA_256)
+	}
+	if policy := IterationPolicyFor(X_SCRAM_SCRYPT_SHA_256); policy.Max != maxScryptN {
+		t.Fatalf("unexpected scrypt policy %+v", policy)
+	}
+}
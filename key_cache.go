@@ -0,0 +1,78 @@
+package scramauth
+
+import (
+	"errors"
+	"sync"
+)
+
+// ErrKeysNotCached is returned by the client when no password is given and
+// the cache has no keys for the server's salt and iteration count.
+var ErrKeysNotCached = errors.New("no password given and no cached keys found")
+
+// KeyCacheKey identifies the keys derived from a password. The salt is
+// kept as a string so the key can be used in maps, KDF is the k attribute
+// of the server-first message.
+type KeyCacheKey struct {
+	Mechanism  string
+	Username   string
+	Salt       string
+	Iterations int
+	KDF        string
+}
+
+// CachedKeys holds what a client needs to authenticate without the
+// password: ClientKey to build the proof and ServerKey to check the
+// server signature.
+type CachedKeys struct {
+	ClientKey []byte
+	ServerKey []byte
+}
+
+// KeyCache stores derived client keys so reconnects can skip PBKDF2. The
+// client only puts keys the server accepted and deletes the ones it
+// rejects. Get returns a copy the caller owns and Put keeps a copy, as the
+// client wipes its keys once the conversation completes.
+type KeyCache interface {
+	Get(key KeyCacheKey) (CachedKeys, bool)
+	Put(key KeyCacheKey, keys CachedKeys)
+	Delete(key KeyCacheKey)
+}
+
+// MemoryKeyCache is an in-memory KeyCache safe for concurrent use.
+type MemoryKeyCache struct {
+	mu   sync.Mutex
+	keys map[KeyCacheKey]CachedKeys
+}
+
+func NewMemoryKeyCache() *MemoryKeyCache {
+	return &MemoryKeyCache{keys: map[KeyCacheKey]CachedKeys{}}
+}
+
+func (cache *MemoryKeyCache) Get(key KeyCacheKey) (CachedKeys, bool) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	keys, ok := cache.keys[key]
+	if !ok {
+		return CachedKeys{}, false
+	}
+	return keys.clone(), true
+}
+
+func (cache *MemoryKeyCache) Put(key KeyCacheKey, keys CachedKeys) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	cache.keys[key] = keys.clone()
+}
+
+// Delete drops the keys stored for key, e.g. after a password change.
+func (cache *MemoryKeyCache) Delete(key KeyCacheKey) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	delete(cache.keys, key)
+}
+
+func (keys CachedKeys) clone() CachedKeys {
+	return CachedKeys{
+		ClientKey: append([]byte{}, keys.ClientKey...),
+		ServerKey: append([]byte{}, keys.ServerKey...)}
+}
@@ -0,0 +1,73 @@
+package scramauth
+
+import (
+	"crypto/sha256"
+	"errors"
+	"testing"
+)
+
+func TestKeyCache(t *testing.T) {
+	cache := NewMemoryKeyCache()
+	login := func(password string) error {
+		client := NewClientScramAuth(sha256.New, None, nil).WithKeyCache(SCRAM_SHA_256, cache)
+		return runExchange(client, NewServerScramAuth(sha256.New, None, nil), password, passwordKeys("12345678", 4096))
+	}
+	if err := login(""); !errors.Is(err, ErrKeysNotCached) {
+		t.Fatalf("expected ErrKeysNotCached, got %v", err)
+	}
+	if err := login("123456"); err != nil {
+		t.Fatalf("first login error: %s", err.Error())
+	}
+	key := KeyCacheKey{Mechanism: SCRAM_SHA_256, Username: "yang-zhong", Salt: "12345678", Iterations: 4096}
+	if _, ok := cache.Get(key); !ok {
+		t.Fatalf("keys not cached after first login")
+	}
+	if err := login(""); err != nil {
+		t.Fatalf("login from cache error: %s", err.Error())
+	}
+	cache.Delete(key)
+	if _, ok := cache.Get(key); ok {
+		t.Fatalf("keys still cached after delete")
+	}
+}
+
+func TestMemoryKeyCacheCopies(t *testing.T) {
+	cache := NewMemoryKeyCache()
+	key := KeyCacheKey{Mechanism: SCRAM_SHA_256, Username: "yang-zhong"}
+	keys := CachedKeys{ClientKey: []byte{1, 2, 3}, ServerKey: []byte{4, 5, 6}}
+	cache.Put(key, keys)
+	keys.ClientKey[0] = 0
+	got, _ := cache.Get(key)
+	if got.ClientKey[0] != 1 {
+		t.Fatalf("cache shares memory with caller")
+	}
+}
+
+func TestKeyCacheNotPoisoned(t *testing.T) {
+	cache := NewMemoryKeyCache()
+	login := func(password string) error {
+		client := NewClientScramAuth(sha256.New, None, nil).WithKeyCache(SCRAM_SHA_256, cache)
+		return runExchange(client, NewServerScramAuth(sha256.New, None, nil), password, passwordKeys("12345678", 4096))
+	}
+	key := KeyCacheKey{Mechanism: SCRAM_SHA_256, Username: "yang-zhong", Salt: "12345678", Iterations: 4096}
+	if err := login("wrong"); !errors.Is(err, ErrInvalidProof) {
+		t.Fatalf("expected ErrInvalidProof, got %v", err)
+	}
+	if _, ok := cache.Get(key); ok {
+		t.Fatalf("keys of a rejected login cached")
+	}
+	if err := login("123456"); err != nil {
+		t.Fatalf("login error: %s", err.Error())
+	}
+	cache.Put(key, CachedKeys{ClientKey: make([]byte, 32), ServerKey: make([]byte, 32)})
+	if err := login("123456"); err != nil {
+		t.Fatalf("login with a password over stale keys error: %s", err.Error())
+	}
+	cache.Put(key, CachedKeys{ClientKey: make([]byte, 32), ServerKey: make([]byte, 32)})
+	if err := login(""); !errors.Is(err, ErrInvalidProof) {
+		t.Fatalf("expected ErrInvalidProof for stale keys, got %v", err)
+	}
+	if _, ok := cache.Get(key); ok {
+		t.Fatalf("rejected keys still cached")
+	}
+}
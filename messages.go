@@ -0,0 +1,248 @@
+package scramauth
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"strconv"
+)
+
+// ClientFirstMessage is the client-first-message of RFC 5802: the
+// gs2-header followed by the client-first-message-bare.
+type ClientFirstMessage struct {
+	// NonStd is the gs2-nonstd-flag "F".
+	NonStd bool
+	// CB is the channel binding type the client uses, None when it
+	// doesn't. SupportsCB is the "y" flag: the client doesn't use channel
+	// binding but supports it.
+	CB         CB
+	SupportsCB bool
+	Authzid    string
+	// Mandatory is the m attribute.
+	Mandatory  string
+	Username   string
+	Nonce      []byte
+	Extensions []Param
+}
+
+// ServerFirstMessage is the server-first-message. Nonce is the client
+// nonce followed by the server nonce.
+type ServerFirstMessage struct {
+	Mandatory  string
+	Nonce      []byte
+	Salt       []byte
+	Iterations int
+	Extensions []Param
+}
+
+// ClientFinalMessage is the client-final-message. ChannelBinding is the
+// decoded c attribute: the gs2-header without the "F" flag and, with
+// channel binding, the channel binding data.
+type ClientFinalMessage struct {
+	ChannelBinding []byte
+	Nonce          []byte
+	Extensions     []Param
+	Proof          []byte
+}
+
+// ServerFinalMessage is the server-final-message, carrying either the
+// server signature in Verifier or an Error.
+type ServerFinalMessage struct {
+	Verifier   []byte
+	Error      ServerError
+	Extensions []Param
+}
+
+func (msg *ClientFirstMessage) header() Gs2Header {
+	cb := msg.CB
+	if cb == "" {
+		cb = None
+	}
+	return Gs2Header{
+		NonStd:     msg.NonStd,
+		CB:         cb,
+		Authzid:    []byte(msg.Authzid),
+		Params:     msg.bare(),
+		supportsCB: msg.SupportsCB && cb == None}
+}
+
+// bare returns the attributes of the client-first-message-bare.
+func (msg *ClientFirstMessage) bare() *Params {
+	p := NewParams()
+	if msg.Mandatory != "" {
+		p.Append(Param{Key: []byte{'m'}, Val: []byte(msg.Mandatory)})
+	}
+	p.Append(
+		Param{Key: []byte{'n'}, Val: escapeSaslname([]byte(msg.Username))},
+		Param{Key: []byte{'r'}, Val: msg.Nonce})
+	p.Append(msg.Extensions...)
+	return p
+}
+
+func (msg *ClientFirstMessage) MarshalText() ([]byte, error) {
+	header := msg.header()
+	var buf bytes.Buffer
+	buf.Write(header.header())
+	if err := NewStrictEncoding(ClientFirstBareGrammar).Encode(&buf, header.Params); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (msg *ClientFirstMessage) UnmarshalText(text []byte) error {
+	var header Gs2Header
+	if _, err := header.decodeWith(append([]byte{}, text...), NewStrictEncoding(ClientFirstBareGrammar)); err != nil {
+		return err
+	}
+	m, _ := header.Params.Val([]byte{'m'})
+	n, _ := header.Params.Val([]byte{'n'})
+	r, _ := header.Params.Val([]byte{'r'})
+	*msg = ClientFirstMessage{
+		NonStd:     header.NonStd,
+		CB:         header.CB,
+		SupportsCB: header.supportsCB,
+		Authzid:    string(header.Authzid),
+		Mandatory:  string(m),
+		Username:   string(unescapeSaslname(n)),
+		Nonce:      r,
+		Extensions: extensionsOf(ClientFirstBareGrammar, header.Params)}
+	return nil
+}
+
+func (msg *ServerFirstMessage) MarshalText() ([]byte, error) {
+	p := NewParams()
+	if msg.Mandatory != "" {
+		p.Append(Param{Key: []byte{'m'}, Val: []byte(msg.Mandatory)})
+	}
+	p.Append(
+		Param{Key: []byte{'r'}, Val: msg.Nonce},
+		Param{Key: []byte{'s'}, Val: []byte(base64.StdEncoding.EncodeToString(msg.Salt))},
+		Param{Key: []byte{'i'}, Val: []byte(strconv.Itoa(msg.Iterations))})
+	p.Append(msg.Extensions...)
+	var buf bytes.Buffer
+	if err := NewStrictEncoding(ServerFirstGrammar).Encode(&buf, p); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (msg *ServerFirstMessage) UnmarshalText(text []byte) error {
+	p := NewParams()
+	if err := NewStrictEncoding(ServerFirstGrammar).Parse(append([]byte{}, text...), p); err != nil {
+		return err
+	}
+	m, _ := p.Val([]byte{'m'})
+	r, _ := p.Val([]byte{'r'})
+	s, _ := p.Val([]byte{'s'})
+	salt, err := base64.StdEncoding.DecodeString(string(s))
+	if err != nil {
+		return err
+	}
+	i, _ := p.Val([]byte{'i'})
+	iter, err := strconv.Atoi(string(i))
+	if err != nil {
+		return errors.New("incorrect challenge format")
+	}
+	*msg = ServerFirstMessage{
+		Mandatory:  string(m),
+		Nonce:      r,
+		Salt:       salt,
+		Iterations: iter,
+		Extensions: extensionsOf(ServerFirstGrammar, p)}
+	return nil
+}
+
+// withoutProof returns the client-final-message-without-proof.
+func (msg *ClientFinalMessage) withoutProof() ([]byte, error) {
+	p := NewParams()
+	p.Append(
+		Param{Key: []byte{'c'}, Val: []byte(base64.StdEncoding.EncodeToString(msg.ChannelBinding))},
+		Param{Key: []byte{'r'}, Val: msg.Nonce})
+	p.Append(msg.Extensions...)
+	var buf bytes.Buffer
+	if err := NewStrictEncoding(clientFinalWithoutProofGrammar).Encode(&buf, p); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (msg *ClientFinalMessage) MarshalText() ([]byte, error) {
+	if len(msg.Proof) == 0 {
+		return nil, &AttributeError{Attr: "p", Reason: "missing"}
+	}
+	out, err := msg.withoutProof()
+	if err != nil {
+		return nil, err
+	}
+	out = append(out, ",p="...)
+	return append(out, base64.StdEncoding.EncodeToString(msg.Proof)...), nil
+}
+
+func (msg *ClientFinalMessage) UnmarshalText(text []byte) error {
+	p := NewParams()
+	if err := NewStrictEncoding(ClientFinalGrammar).Parse(append([]byte{}, text...), p); err != nil {
+		return err
+	}
+	c, _ := p.Val([]byte{'c'})
+	cb, err := base64.StdEncoding.DecodeString(string(c))
+	if err != nil {
+		return err
+	}
+	r, _ := p.Val([]byte{'r'})
+	pr, _ := p.Val([]byte{'p'})
+	proof, err := base64.StdEncoding.DecodeString(string(pr))
+	if err != nil {
+		return err
+	}
+	*msg = ClientFinalMessage{
+		ChannelBinding: cb,
+		Nonce:          r,
+		Extensions:     extensionsOf(ClientFinalGrammar, p),
+		Proof:          proof}
+	return nil
+}
+
+func (msg *ServerFinalMessage) MarshalText() ([]byte, error) {
+	p := NewParams()
+	if msg.Error != "" {
+		p.Append(Param{Key: []byte{'e'}, Val: []byte(msg.Error)})
+	} else {
+		p.Append(Param{Key: []byte{'v'}, Val: []byte(base64.StdEncoding.EncodeToString(msg.Verifier))})
+	}
+	p.Append(msg.Extensions...)
+	var buf bytes.Buffer
+	if err := NewStrictEncoding(ServerFinalGrammar).Encode(&buf, p); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (msg *ServerFinalMessage) UnmarshalText(text []byte) error {
+	p := NewParams()
+	if err := NewStrictEncoding(ServerFinalGrammar).Parse(append([]byte{}, text...), p); err != nil {
+		return err
+	}
+	*msg = ServerFinalMessage{Extensions: extensionsOf(ServerFinalGrammar, p)}
+	if e, ok := p.Val([]byte{'e'}); ok {
+		msg.Error = ServerError(e)
+		return nil
+	}
+	v, _ := p.Val([]byte{'v'})
+	verifier, err := base64.StdEncoding.DecodeString(string(v))
+	if err != nil {
+		return err
+	}
+	msg.Verifier = verifier
+	return nil
+}
+
+// extensionsOf returns the attributes of p not defined by grammar.
+func extensionsOf(grammar Grammar, p *Params) []Param {
+	var attrs []Param
+	for _, param := range p.All() {
+		if !grammar.known(param.Key[0]) {
+			attrs = append(attrs, param)
+		}
+	}
+	return attrs
+}
@@ -0,0 +1,100 @@
+package scramauth
+
+import (
+	"bytes"
+	"encoding"
+	"testing"
+)
+
+type textMessage interface {
+	encoding.TextMarshaler
+	encoding.TextUnmarshaler
+}
+
+func TestMessagesRoundTrip(t *testing.T) {
+	for _, v := range conformanceVectors {
+		for _, c := range []struct {
+			text string
+			msg  textMessage
+		}{
+			{v.clientFirst, &ClientFirstMessage{}},
+			{v.serverFirst, &ServerFirstMessage{}},
+			{v.clientFinal, &ClientFinalMessage{}},
+			{v.serverFinal, &ServerFinalMessage{}},
+		} {
+			if err := c.msg.UnmarshalText([]byte(c.text)); err != nil {
+				t.Fatalf("%s: unmarshal %s: %s", v.name, c.text, err.Error())
+			}
+			out, err := c.msg.MarshalText()
+			if err != nil {
+				t.Fatalf("%s: marshal %s: %s", v.name, c.text, err.Error())
+			}
+			if string(out) != c.text {
+				t.Fatalf("%s: expected %s, got %s", v.name, c.text, out)
+			}
+		}
+	}
+}
+
+func TestMessagesFields(t *testing.T) {
+	var clientFirst ClientFirstMessage
+	if err := clientFirst.UnmarshalText([]byte("F,y,a=ad=2Cmin,m=ext,n=us=3Der,r=abc,x=1")); err != nil {
+		t.Fatalf("unmarshal client-first: %s", err.Error())
+	}
+	if !clientFirst.NonStd || clientFirst.CB != None || !clientFirst.SupportsCB ||
+		clientFirst.Authzid != "ad,min" || clientFirst.Mandatory != "ext" ||
+		clientFirst.Username != "us=er" || string(clientFirst.Nonce) != "abc" ||
+		len(clientFirst.Extensions) != 1 || string(clientFirst.Extensions[0].Key) != "x" {
+		t.Fatalf("unexpected client-first %+v", clientFirst)
+	}
+
+	var serverFirst ServerFirstMessage
+	if err := serverFirst.UnmarshalText([]byte("r=abcdef,s=c2FsdA==,i=4096,k=argon2id")); err != nil {
+		t.Fatalf("unmarshal server-first: %s", err.Error())
+	}
+	if string(serverFirst.Nonce) != "abcdef" || string(serverFirst.Salt) != "salt" ||
+		serverFirst.Iterations != 4096 || len(serverFirst.Extensions) != 1 {
+		t.Fatalf("unexpected server-first %+v", serverFirst)
+	}
+
+	var clientFinal ClientFinalMessage
+	if err := clientFinal.UnmarshalText([]byte("c=cD10bHMtdW5pcXVlLCwAAQ==,r=abcdef,p=cHJvb2Y=")); err != nil {
+		t.Fatalf("unmarshal client-final: %s", err.Error())
+	}
+	if !bytes.Equal(clientFinal.ChannelBinding, []byte("p=tls-unique,,\x00\x01")) ||
+		string(clientFinal.Nonce) != "abcdef" || string(clientFinal.Proof) != "proof" {
+		t.Fatalf("unexpected client-final %+v", clientFinal)
+	}
+
+	var serverFinal ServerFinalMessage
+	if err := serverFinal.UnmarshalText([]byte("e=invalid-proof")); err != nil {
+		t.Fatalf("unmarshal server-final: %s", err.Error())
+	}
+	if serverFinal.Error != ErrInvalidProof || serverFinal.Verifier != nil {
+		t.Fatalf("unexpected server-final %+v", serverFinal)
+	}
+}
+
+func TestMessagesInvalid(t *testing.T) {
+	for _, c := range []struct {
+		text string
+		msg  textMessage
+	}{
+		{"n,,r=abc,n=user", &ClientFirstMessage{}},
+		{"n,,n=us=er,r=abc", &ClientFirstMessage{}},
+		{"r=abc,s=c2FsdA==,i=0", &ServerFirstMessage{}},
+		{"r=abc,s=salt!,i=4096", &ServerFirstMessage{}},
+		{"c=biws,r=abc", &ClientFinalMessage{}},
+		{"v=abc,e=other-error", &ServerFinalMessage{}},
+	} {
+		if err := c.msg.UnmarshalText([]byte(c.text)); err == nil {
+			t.Fatalf("%s: expected error", c.text)
+		}
+	}
+	if _, err := (&ClientFirstMessage{Nonce: []byte("abc")}).MarshalText(); err == nil {
+		t.Fatalf("expected error for missing username")
+	}
+	if _, err := (&ClientFinalMessage{ChannelBinding: []byte("n,,"), Nonce: []byte("abc")}).MarshalText(); err == nil {
+		t.Fatalf("expected error for missing proof")
+	}
+}
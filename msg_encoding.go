@@ -2,7 +2,6 @@ package scramauth
 
 import (
 	"bytes"
-	"errors"
 	"fmt"
 	"io"
 )
@@ -23,17 +22,41 @@ type Param struct {
 
 type Params struct {
 	params []Param
+	// index holds the position + 1 of the first param for each single
+	// letter key, so SCRAM attributes are looked up without a scan.
+	index [52]int32
 }
 
 func NewParams() *Params {
-	return &Params{[]Param{}}
+	return &Params{params: []Param{}}
 }
 
 func NewParamsWith(params []Param) *Params {
-	return &Params{params}
+	ps := &Params{}
+	ps.Append(params...)
+	return ps
+}
+
+func letterIndex(k []byte) int {
+	if len(k) != 1 {
+		return -1
+	}
+	switch c := k[0]; {
+	case c >= 'a' && c <= 'z':
+		return int(c - 'a')
+	case c >= 'A' && c <= 'Z':
+		return int(c-'A') + 26
+	}
+	return -1
 }
 
 func (ps *Params) Val(k []byte) ([]byte, bool) {
+	if i := letterIndex(k); i >= 0 {
+		if pos := ps.index[i]; pos > 0 {
+			return ps.params[pos-1].Val, true
+		}
+		return []byte{}, false
+	}
 	for _, p := range ps.params {
 		if bytes.Equal(k, p.Key) {
 			return p.Val, true
@@ -43,7 +66,12 @@ func (ps *Params) Val(k []byte) ([]byte, bool) {
 }
 
 func (ps *Params) Append(p ...Param) {
-	ps.params = append(ps.params, p...)
+	for _, param := range p {
+		if i := letterIndex(param.Key); i >= 0 && ps.index[i] == 0 {
+			ps.index[i] = int32(len(ps.params) + 1)
+		}
+		ps.params = append(ps.params, param)
+	}
 }
 
 func (ps *Params) All() []Param {
@@ -55,94 +83,94 @@ func (ps *Params) Len() int {
 }
 
 type Encoding struct {
-	state  int
-	offset int
+	max     int
+	grammar *Grammar
 }
 
 func NewEncoding() *Encoding {
-	return &Encoding{}
+	return &Encoding{max: DefaultMaxMessageSize}
 }
 
-func (encoding *Encoding) read(b []byte, r io.Reader) error {
-	if _, err := r.Read(b); err != nil {
-		return err
-	}
-	encoding.offset = encoding.offset + 1
-	return nil
+// WithMaxSize sets how many bytes Decode reads before giving up with a
+// MessageTooLargeError. Zero means no limit.
+func (encoding *Encoding) WithMaxSize(max int) *Encoding {
+	encoding.max = max
+	return encoding
 }
 
-func (encoding *Encoding) param(key, val []byte) Param {
-	param := Param{Key: make([]byte, len(key)), Val: make([]byte, len(val))}
-	copy(param.Key, key)
-	copy(param.Val, val)
-	return param
-}
-
-func (encoding *Encoding) Encode(w io.Writer, p *Params) (err error) {
-	defer func() {
-		if e := recover(); e != nil {
-			err = e.(error)
-		}
-	}()
-	write := func(w io.Writer, b []byte) {
-		if err := FullWrite(w, b); err != nil {
-			panic(err)
+func (encoding *Encoding) Encode(w io.Writer, p *Params) error {
+	params := p.All()
+	if encoding.grammar != nil {
+		if err := encoding.grammar.validate(params); err != nil {
+			return err
 		}
 	}
-	params := p.All()
-	l := len(params)
+	var buf bytes.Buffer
 	for i, p := range params {
-		write(w, p.Key)
-		if len(p.Val) != 0 {
-			write(w, []byte{'='})
+		if i > 0 {
+			buf.WriteByte(',')
 		}
-		write(w, p.Val)
-		if i < l-1 {
-			write(w, []byte{','})
+		buf.Write(p.Key)
+		if len(p.Val) != 0 || encoding.grammar != nil {
+			buf.WriteByte('=')
 		}
+		buf.Write(p.Val)
 	}
-	return
+	return FullWrite(w, buf.Bytes())
 }
 
+// Decode reads r up to EOF and parses it with Parse.
 func (encoding *Encoding) Decode(r io.Reader, params *Params) error {
-	b := make([]byte, 1)
-	encoding.state = statekey
-	encoding.offset = 0
-	var key []byte
-	var val []byte
-	forward := 0
-	pushFrag := func() {
-		params.Append(encoding.param(key, val))
-		key = []byte{}
-		val = []byte{}
-		forward = forward + 1
-		encoding.state = statekey
+	var msg []byte
+	var err error
+	if encoding.max > 0 {
+		msg, err = EOFFraming{}.ReadMessage(r, encoding.max)
+	} else {
+		msg, err = io.ReadAll(r)
+	}
+	if err != nil {
+		return err
+	}
+	return encoding.Parse(msg, params)
+}
+
+// Parse parses msg in a single pass. The keys and values of the appended
+// params reference msg, which must not be modified while they are in use.
+func (encoding *Encoding) Parse(msg []byte, params *Params) error {
+	if n := bytes.Count(msg, []byte{','}) + 1; cap(params.params)-len(params.params) < n {
+		params.params = append(make([]Param, 0, len(params.params)+n), params.params...)
 	}
-	for {
-		if err := encoding.read(b, r); err != nil {
-			if errors.Is(err, io.EOF) {
-				params.Append(encoding.param(key, val))
-				return nil
+	first := params.Len()
+	state := statekey
+	start, eq := 0, -1
+	for i := 0; i <= len(msg); i++ {
+		if i == len(msg) || msg[i] == ',' {
+			if eq < 0 && encoding.grammar != nil {
+				return &AttributeError{Attr: string(msg[start:i]), Reason: "missing \"=\""}
 			}
-			return err
-		}
-		switch encoding.state {
-		case statekey:
-			if b[0] >= 'a' && b[0] <= 'z' || b[0] == '_' || b[0] == '-' {
-				key = append(key, b[0])
-			} else if b[0] == '=' {
-				encoding.state = stateval
-			} else if b[0] == ',' {
-				pushFrag()
+			if eq < 0 {
+				params.Append(Param{Key: msg[start:i:i], Val: msg[i:i:i]})
 			} else {
-				return errOccured(encoding.offset, b)
+				params.Append(Param{Key: msg[start:eq:eq], Val: msg[eq+1 : i : i]})
 			}
-		case stateval:
-			if b[0] != ',' {
-				val = append(val, b[0])
-				continue
-			}
-			pushFrag()
+			state, start, eq = statekey, i+1, -1
+			continue
+		}
+		if state == stateval {
+			continue
 		}
+		if b := msg[i]; encoding.grammar == nil && (b >= 'a' && b <= 'z' || b == '_' || b == '-') {
+			continue
+		} else if encoding.grammar != nil && isAlpha(b) {
+			continue
+		} else if b == '=' {
+			state, eq = stateval, i
+		} else {
+			return errOccured(i+1, msg[i:i+1])
+		}
+	}
+	if encoding.grammar != nil {
+		return encoding.grammar.validate(params.All()[first:])
 	}
+	return nil
 }
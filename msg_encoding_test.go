@@ -2,7 +2,9 @@ package scramauth
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
+	"io"
 	"testing"
 )
 
@@ -37,3 +39,115 @@ func TestMsgDecode(t *testing.T) {
 		t.Fatalf("encoding encode error")
 	}
 }
+
+func TestMsgParse(t *testing.T) {
+	msg := []byte("n,,n=user,r=fyko+d2lbbFgONRv9qkxdawL,r=again")
+	params := NewParams()
+	if err := NewEncoding().Parse(msg, params); err != nil {
+		t.Fatalf("parse error: %s", err.Error())
+	}
+	if params.Len() != 5 {
+		t.Fatalf("unexpected params count: %d", params.Len())
+	}
+	r, ok := params.Val([]byte{'r'})
+	if !ok || string(r) != "fyko+d2lbbFgONRv9qkxdawL" {
+		t.Fatalf("unexpected r: %s", r)
+	}
+	if &r[0] != &msg[12] {
+		t.Fatalf("parsed value doesn't reference the input")
+	}
+	if n, ok := params.Val([]byte{'n'}); !ok || len(n) != 0 {
+		t.Fatalf("unexpected n: %s", n)
+	}
+	if _, ok := params.Val([]byte{'s'}); ok {
+		t.Fatalf("unexpected s")
+	}
+	if err := NewEncoding().Parse([]byte("n,,N=user"), NewParams()); err == nil || err.Error() != "error on 4: unexpected char [N]" {
+		t.Fatalf("unexpected parse error: %v", err)
+	}
+}
+
+func TestParamsValMultiLetterKey(t *testing.T) {
+	params := NewParamsWith([]Param{{Key: []byte("ext"), Val: []byte("1")}, {Key: []byte{'e'}, Val: []byte("2")}})
+	if v, ok := params.Val([]byte("ext")); !ok || string(v) != "1" {
+		t.Fatalf("unexpected ext: %s", v)
+	}
+	if v, ok := params.Val([]byte{'e'}); !ok || string(v) != "2" {
+		t.Fatalf("unexpected e: %s", v)
+	}
+}
+
+// legacyDecode is the byte at a time decoder Parse replaced, kept to
+// benchmark against.
+func legacyDecode(r io.Reader, params *Params) error {
+	b := make([]byte, 1)
+	state := statekey
+	var key, val []byte
+	push := func() {
+		params.Append(Param{Key: append([]byte{}, key...), Val: append([]byte{}, val...)})
+		key, val = []byte{}, []byte{}
+		state = statekey
+	}
+	for offset := 1; ; offset++ {
+		if _, err := r.Read(b); err != nil {
+			if errors.Is(err, io.EOF) {
+				push()
+				return nil
+			}
+			return err
+		}
+		switch state {
+		case statekey:
+			if b[0] >= 'a' && b[0] <= 'z' || b[0] == '_' || b[0] == '-' {
+				key = append(key, b[0])
+			} else if b[0] == '=' {
+				state = stateval
+			} else if b[0] == ',' {
+				push()
+			} else {
+				return errOccured(offset, b)
+			}
+		case stateval:
+			if b[0] != ',' {
+				val = append(val, b[0])
+				continue
+			}
+			push()
+		}
+	}
+}
+
+var benchMsg = []byte("c=biws,r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,p=dHzbZapWIk4jUhN+Ute9ytag9zjfMHgsqmmiz7AndVQ=")
+
+func BenchmarkLegacyDecode(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		p := NewParams()
+		if err := legacyDecode(bytes.NewReader(benchMsg), p); err != nil {
+			b.Fatal(err)
+		}
+		p.Val([]byte{'p'})
+	}
+}
+
+func BenchmarkDecode(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		p := NewParams()
+		if err := NewEncoding().Decode(bytes.NewReader(benchMsg), p); err != nil {
+			b.Fatal(err)
+		}
+		p.Val([]byte{'p'})
+	}
+}
+
+func BenchmarkParse(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		p := NewParams()
+		if err := NewEncoding().Parse(benchMsg, p); err != nil {
+			b.Fatal(err)
+		}
+		p.Val([]byte{'p'})
+	}
+}
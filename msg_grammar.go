@@ -0,0 +1,200 @@
+package scramauth
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// Grammar is the attribute layout of a SCRAM message as in RFC 5802
+// section 7: optional leading attributes, the mandatory ones in order, any
+// extensions, then the attributes that close the message.
+type Grammar struct {
+	// Leading attributes may appear, in this order, before Mandatory.
+	Leading string
+	// Mandatory lists, for each position, the attributes accepted there.
+	Mandatory []string
+	// Trailing attributes must close the message, in this order.
+	Trailing string
+}
+
+var (
+	ClientFirstBareGrammar = Grammar{Leading: "m", Mandatory: []string{"n", "r"}}
+	ServerFirstGrammar     = Grammar{Leading: "m", Mandatory: []string{"r", "s", "i"}}
+	ClientFinalGrammar     = Grammar{Mandatory: []string{"c", "r"}, Trailing: "p"}
+	ServerFinalGrammar     = Grammar{Mandatory: []string{"ev"}}
+
+	// clientFinalWithoutProofGrammar is the client-final-message-without-proof
+	// of the AuthMessage.
+	clientFinalWithoutProofGrammar = Grammar{Mandatory: []string{"c", "r"}}
+)
+
+// AttributeError is returned by a strict Encoding when a message breaks
+// the SCRAM attribute grammar.
+type AttributeError struct {
+	Attr   string
+	Reason string
+}
+
+func (e *AttributeError) Error() string {
+	if e.Attr == "" {
+		return "invalid attribute: " + e.Reason
+	}
+	return fmt.Sprintf("invalid attribute %q: %s", e.Attr, e.Reason)
+}
+
+// NewStrictEncoding returns an Encoding for the messages described by
+// grammar. It requires single ALPHA keys, each with "=" and a value valid
+// for the attribute, in the order of grammar and without duplicates, and
+// always writes "=" even for empty values.
+func NewStrictEncoding(grammar Grammar) *Encoding {
+	return &Encoding{max: DefaultMaxMessageSize, grammar: &grammar}
+}
+
+func isAlpha(b byte) bool {
+	return b >= 'a' && b <= 'z' || b >= 'A' && b <= 'Z'
+}
+
+func (grammar *Grammar) known(key byte) bool {
+	return strings.IndexByte(grammar.Leading, key) >= 0 ||
+		strings.IndexByte(grammar.Trailing, key) >= 0 ||
+		strings.IndexByte(strings.Join(grammar.Mandatory, ""), key) >= 0
+}
+
+func (grammar *Grammar) validate(params []Param) error {
+	var seen [52]bool
+	for _, p := range params {
+		if len(p.Key) != 1 || !isAlpha(p.Key[0]) {
+			return &AttributeError{Attr: string(p.Key), Reason: "key must be a single letter"}
+		}
+		i := letterIndex(p.Key)
+		if seen[i] {
+			return &AttributeError{Attr: string(p.Key), Reason: "duplicate attribute"}
+		}
+		seen[i] = true
+		rule := validValueChars
+		if grammar.known(p.Key[0]) {
+			rule = valueRules[p.Key[0]]
+		}
+		if reason := rule(p.Val); reason != "" {
+			return &AttributeError{Attr: string(p.Key), Reason: reason}
+		}
+	}
+	pos := 0
+	for i := 0; i < len(grammar.Leading); i++ {
+		if pos < len(params) && params[pos].Key[0] == grammar.Leading[i] {
+			pos++
+		}
+	}
+	for _, accepted := range grammar.Mandatory {
+		if pos >= len(params) || strings.IndexByte(accepted, params[pos].Key[0]) < 0 {
+			return &AttributeError{Attr: accepted, Reason: "missing or out of order"}
+		}
+		pos++
+	}
+	end := len(params) - len(grammar.Trailing)
+	if end < pos {
+		return &AttributeError{Attr: grammar.Trailing, Reason: "missing"}
+	}
+	for i := 0; i < len(grammar.Trailing); i++ {
+		if params[end+i].Key[0] != grammar.Trailing[i] {
+			return &AttributeError{Attr: grammar.Trailing[i : i+1], Reason: "missing or out of order"}
+		}
+	}
+	for _, p := range params[pos:end] {
+		if grammar.known(p.Key[0]) {
+			return &AttributeError{Attr: string(p.Key), Reason: "out of order"}
+		}
+	}
+	return nil
+}
+
+var valueRules = map[byte]func([]byte) string{
+	'a': validSaslname,
+	'n': validSaslname,
+	'm': validValueChars,
+	'e': validValueChars,
+	'r': validPrintable,
+	'i': validPositNumber,
+	's': validBase64,
+	'c': validBase64,
+	'p': validBase64,
+	'v': validBase64,
+}
+
+// value-char = any UTF-8 char but NUL and ","
+func validValueChars(val []byte) string {
+	if len(val) == 0 {
+		return "empty value"
+	}
+	if !utf8.Valid(val) {
+		return "invalid UTF-8"
+	}
+	if bytes.IndexByte(val, 0) >= 0 || bytes.IndexByte(val, ',') >= 0 {
+		return "value contains NUL or comma"
+	}
+	return ""
+}
+
+// saslname = 1*(value-safe-char / "=2C" / "=3D")
+func validSaslname(val []byte) string {
+	if reason := validValueChars(val); reason != "" {
+		return reason
+	}
+	for i := bytes.IndexByte(val, '='); i >= 0; i = bytes.IndexByte(val, '=') {
+		if !bytes.HasPrefix(val[i:], []byte("=2C")) && !bytes.HasPrefix(val[i:], []byte("=3D")) {
+			return "\"=\" must be escaped as =3D"
+		}
+		val = val[i+3:]
+	}
+	return ""
+}
+
+// printable = %x21-2B / %x2D-7E
+func validPrintable(val []byte) string {
+	if len(val) == 0 {
+		return "empty value"
+	}
+	for _, b := range val {
+		if b < 0x21 || b > 0x7e || b == ',' {
+			return "value must be printable ASCII"
+		}
+	}
+	return ""
+}
+
+// posit-number = %x31-39 *DIGIT
+func validPositNumber(val []byte) string {
+	if len(val) == 0 || val[0] < '1' || val[0] > '9' {
+		return "value must be a positive number"
+	}
+	for _, b := range val {
+		if b < '0' || b > '9' {
+			return "value must be a positive number"
+		}
+	}
+	return ""
+}
+
+func validBase64(val []byte) string {
+	if len(val) == 0 {
+		return "empty value"
+	}
+	if _, err := base64.StdEncoding.Strict().DecodeString(string(val)); err != nil {
+		return "value must be base64"
+	}
+	return ""
+}
+
+// escapeSaslname encodes a username or authzid as a saslname.
+func escapeSaslname(name []byte) []byte {
+	name = bytes.ReplaceAll(name, []byte("="), []byte("=3D"))
+	return bytes.ReplaceAll(name, []byte(","), []byte("=2C"))
+}
+
+func unescapeSaslname(name []byte) []byte {
+	name = bytes.ReplaceAll(name, []byte("=2C"), []byte(","))
+	return bytes.ReplaceAll(name, []byte("=3D"), []byte("="))
+}
@@ -0,0 +1,103 @@
+package scramauth
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"testing"
+)
+
+func TestStrictParse(t *testing.T) {
+	for _, c := range []struct {
+		grammar Grammar
+		msg     string
+	}{
+		{ClientFirstBareGrammar, "n=user,r=fyko+d2lbbFgONRv9qkxdawL"},
+		{ClientFirstBareGrammar, "m=ext,n=us=2Cer=3D,r=fyko+d2lbbFgONRv9qkxdawL,x=ext"},
+		{ServerFirstGrammar, "r=fyko+d2lbbFgONRv9qkxdawL3rfcNHYJY1ZVvWVs7j,s=QSXCR+Q6sek8bf92,i=4096"},
+		{ClientFinalGrammar, "c=biws,r=fyko+d2lbbFgONRv9qkxdawL3rfcNHYJY1ZVvWVs7j,p=v0X8v3Bz2T0CJGbJQyF0X+HI4Ts="},
+		{ClientFinalGrammar, "c=biws,r=abc,T=totp,p=dg=="},
+		{ServerFinalGrammar, "v=rmF9pqV8S7suAoZWja4dJRkFsKQ="},
+		{ServerFinalGrammar, "e=invalid-proof"},
+	} {
+		if err := NewStrictEncoding(c.grammar).Parse([]byte(c.msg), NewParams()); err != nil {
+			t.Fatalf("%s: %s", c.msg, err.Error())
+		}
+	}
+}
+
+func TestStrictParseRejects(t *testing.T) {
+	for _, c := range []struct {
+		grammar Grammar
+		msg     string
+	}{
+		{ClientFirstBareGrammar, "name=user,r=abc"},
+		{ClientFirstBareGrammar, "n=user,r"},
+		{ClientFirstBareGrammar, "n=user,r="},
+		{ClientFirstBareGrammar, "n=u=er,r=abc"},
+		{ClientFirstBareGrammar, "n=user,r=ab c"},
+		{ClientFirstBareGrammar, "r=abc,n=user"},
+		{ClientFirstBareGrammar, "n=user,r=abc,r=abc"},
+		{ClientFirstBareGrammar, "n=user,r=abc,m=ext"},
+		{ClientFirstBareGrammar, "n=user,r=abc,"},
+		{ClientFirstBareGrammar, "n=user,r=abc,x="},
+		{ServerFirstGrammar, "r=abc,s=not base64,i=4096"},
+		{ServerFirstGrammar, "r=abc,s=QSXCR+Q6sek8bf92,i=0"},
+		{ServerFirstGrammar, "r=abc,s=QSXCR+Q6sek8bf92"},
+		{ServerFirstGrammar, "r=abc,s=,i=4096"},
+		{ClientFinalGrammar, "c=biws,r=abc"},
+		{ClientFinalGrammar, "c=,r=abc,p=dg=="},
+		{ClientFinalGrammar, "c=biws,r=abc,p="},
+		{ServerFinalGrammar, "v="},
+		{ClientFinalGrammar, "c=biws,r=abc,p=dg==,x=ext"},
+		{ServerFinalGrammar, "x=ext"},
+		{ServerFinalGrammar, "v=dg==,e=other-error"},
+	} {
+		var ae *AttributeError
+		if err := NewStrictEncoding(c.grammar).Parse([]byte(c.msg), NewParams()); !errors.As(err, &ae) {
+			t.Fatalf("%s: expected AttributeError, got %v", c.msg, err)
+		}
+	}
+}
+
+func TestStrictEncode(t *testing.T) {
+	p := NewParamsWith([]Param{{Key: []byte{'c'}, Val: []byte("biws")}, {Key: []byte{'r'}, Val: []byte("abc")}, {Key: []byte{'p'}, Val: []byte("dg==")}})
+	var buf bytes.Buffer
+	if err := NewStrictEncoding(ClientFinalGrammar).Encode(&buf, p); err != nil {
+		t.Fatalf("encode error: %s", err.Error())
+	}
+	if buf.String() != "c=biws,r=abc,p=dg==" {
+		t.Fatalf("unexpected strict encoding: %s", buf.String())
+	}
+	p = NewParamsWith([]Param{{Key: []byte{'r'}, Val: []byte("abc")}})
+	if err := NewStrictEncoding(ClientFinalGrammar).Encode(&bytes.Buffer{}, p); err == nil {
+		t.Fatalf("strict encoding of an incomplete message should fail")
+	}
+}
+
+func TestSaslnameEscaping(t *testing.T) {
+	escaped := escapeSaslname([]byte("a=b,c"))
+	if string(escaped) != "a=3Db=2Cc" {
+		t.Fatalf("unexpected escaping: %s", escaped)
+	}
+	if string(unescapeSaslname(escaped)) != "a=b,c" {
+		t.Fatalf("unexpected unescaping: %s", unescapeSaslname(escaped))
+	}
+}
+
+func TestUsernameEscapedOnTheWire(t *testing.T) {
+	client := NewClientScramAuth(sha256.New, None, nil)
+	var req bytes.Buffer
+	if err := client.WriteReqMsg("", "yang,zhong=", &req); err != nil {
+		t.Fatalf("write req msg error: %s", err.Error())
+	}
+	server := NewServerScramAuth(sha256.New, None, nil)
+	if err := server.WriteChallengeMsg(&req, func(username []byte) ([]byte, int, error) {
+		if string(username) != "yang,zhong=" {
+			t.Fatalf("unexpected username: %s", username)
+		}
+		return []byte("12345678"), 4096, nil
+	}, &bytes.Buffer{}); err != nil {
+		t.Fatalf("write challenge msg error: %s", err.Error())
+	}
+}
@@ -0,0 +1,200 @@
+package scramauth
+
+import (
+	"errors"
+	"expvar"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// EventKind is a step of a conversation reported to an Observer.
+type EventKind int
+
+const (
+	// EventStarted is reported when a conversation starts: the client
+	// writes its first message, the server starts reading it.
+	EventStarted EventKind = iota
+	// EventUserLookup is reported by the server once FindSaltIter
+	// returned. Err is ErrUnknownUser for unknown users, even when they
+	// are simulated.
+	EventUserLookup
+	// EventChallenge is reported by the server once the server-first
+	// message is written or the client-first message was rejected.
+	EventChallenge
+	// EventKeyDerivation is reported after deriving the salted password,
+	// with the time it took in Duration.
+	EventKeyDerivation
+	// EventResponse is reported by the client once the client-final
+	// message is written or the server-first message was rejected.
+	EventResponse
+	// EventProof is reported by the server once the client proof was
+	// checked.
+	EventProof
+	// EventSignature is reported by the client once the server signature
+	// was checked.
+	EventSignature
+)
+
+func (kind EventKind) String() string {
+	switch kind {
+	case EventStarted:
+		return "started"
+	case EventUserLookup:
+		return "user_lookup"
+	case EventChallenge:
+		return "challenge"
+	case EventKeyDerivation:
+		return "key_derivation"
+	case EventResponse:
+		return "response"
+	case EventProof:
+		return "proof"
+	case EventSignature:
+		return "signature"
+	}
+	return "unknown"
+}
+
+// Event is a step of a conversation. Err is nil when the step succeeded,
+// otherwise the reason it failed.
+type Event struct {
+	Kind      EventKind
+	Mechanism string
+	Err       error
+	Duration  time.Duration
+}
+
+// Observer is told about every step of the conversations it is registered
+// with. Observers shared between conversations are called concurrently.
+type Observer interface {
+	Observe(event Event)
+}
+
+// ObserverFunc adapts a function to an Observer.
+type ObserverFunc func(event Event)
+
+func (f ObserverFunc) Observe(event Event) {
+	f(event)
+}
+
+// WithObserver reports the steps of the conversation to observer, with
+// mechanism as the Mechanism of the events.
+func (client *ClientScramAuth) WithObserver(mechanism string, observer Observer) *ClientScramAuth {
+	client.scramAuth.mechanism = mechanism
+	client.scramAuth.observer = observer
+	return client
+}
+
+// WithObserver reports the steps of the conversation to observer, with
+// mechanism as the Mechanism of the events.
+func (server *ServerScramAuth) WithObserver(mechanism string, observer Observer) *ServerScramAuth {
+	server.scramAuth.mechanism = mechanism
+	server.scramAuth.observer = observer
+	return server
+}
+
+// WithObserver returns a copy of the server reporting the steps of every
+// conversation to observer.
+func (server *Server) WithObserver(observer Observer) *Server {
+	s := *server
+	s.observer = observer
+	return &s
+}
+
+// WithObserver returns a copy of the client reporting the steps of every
+// conversation to observer.
+func (client *Client) WithObserver(observer Observer) *Client {
+	c := *client
+	c.observer = observer
+	return &c
+}
+
+func (sa *scramAuth) observe(kind EventKind, err error) {
+	if sa.observer != nil {
+		sa.observer.Observe(Event{Kind: kind, Mechanism: sa.mechanism, Err: err})
+	}
+}
+
+// EventReason returns the label of the outcome of event: "ok", the
+// server-error-value of a ServerError, "invalid-server-signature",
+// "not-authorized", "temporarily-locked" or "error".
+func EventReason(event Event) string {
+	if event.Err == nil {
+		return "ok"
+	}
+	return reasonOf(event.Err)
+}
+
+func reasonOf(err error) string {
+	var se ServerError
+	switch {
+	case errors.As(err, &se):
+		return string(se)
+	case errors.Is(err, ErrServerSignature):
+		return "invalid-server-signature"
+	case errors.Is(err, ErrNotAuthorized):
+		return "not-authorized"
+	case errors.Is(err, ErrTemporarilyLocked):
+		return "temporarily-locked"
+	}
+	return "error"
+}
+
+// keyDerivationBuckets are the upper bounds, in seconds, of the key
+// derivation histogram.
+var keyDerivationBuckets = []float64{0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5}
+
+// ExpvarObserver exposes events through expvar: the events map counts
+// them by "mechanism/step/reason", the key_derivation_seconds map holds a
+// histogram per mechanism with cumulative "le_<seconds>" buckets, "sum"
+// and "count".
+type ExpvarObserver struct {
+	events        *expvar.Map
+	keyDerivation *expvar.Map
+
+	mu         sync.Mutex
+	histograms map[string]*expvar.Map
+}
+
+// NewExpvarObserver publishes the observer's variables as name, which
+// like any expvar must be unique in the process.
+func NewExpvarObserver(name string) *ExpvarObserver {
+	observer := &ExpvarObserver{
+		events:        new(expvar.Map).Init(),
+		keyDerivation: new(expvar.Map).Init(),
+		histograms:    map[string]*expvar.Map{}}
+	vars := expvar.NewMap(name)
+	vars.Set("events", observer.events)
+	vars.Set("key_derivation_seconds", observer.keyDerivation)
+	return observer
+}
+
+func (observer *ExpvarObserver) Observe(event Event) {
+	observer.events.Add(event.Mechanism+"/"+event.Kind.String()+"/"+EventReason(event), 1)
+	if event.Kind != EventKeyDerivation || event.Err != nil {
+		return
+	}
+	h := observer.histogram(event.Mechanism)
+	seconds := event.Duration.Seconds()
+	for _, le := range keyDerivationBuckets {
+		if seconds <= le {
+			h.Add("le_"+strconv.FormatFloat(le, 'g', -1, 64), 1)
+		}
+	}
+	h.Add("le_inf", 1)
+	h.AddFloat("sum", seconds)
+	h.Add("count", 1)
+}
+
+func (observer *ExpvarObserver) histogram(mechanism string) *expvar.Map {
+	observer.mu.Lock()
+	defer observer.mu.Unlock()
+	h, ok := observer.histograms[mechanism]
+	if !ok {
+		h = new(expvar.Map).Init()
+		observer.histograms[mechanism] = h
+		observer.keyDerivation.Set(mechanism, h)
+	}
+	return h
+}
@@ -0,0 +1,111 @@
+package scramauth
+
+import (
+	"encoding/json"
+	"expvar"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type eventLog struct {
+	mu     sync.Mutex
+	events []string
+}
+
+func (log *eventLog) Observe(event Event) {
+	log.mu.Lock()
+	defer log.mu.Unlock()
+	log.events = append(log.events, event.Mechanism+" "+event.Kind.String()+" "+EventReason(event))
+}
+
+func (log *eventLog) take() string {
+	log.mu.Lock()
+	defer log.mu.Unlock()
+	out := strings.Join(log.events, "\n")
+	log.events = nil
+	return out
+}
+
+func TestObserver(t *testing.T) {
+	serverLog, clientLog := &eventLog{}, &eventLog{}
+	server, _ := newTestServer(t, 1)
+	server = server.WithObserver(serverLog)
+	client, err := NewClient(SCRAM_SHA_256)
+	if err != nil {
+		t.Fatalf("new client error: %s", err.Error())
+	}
+	client = client.WithObserver(clientLog)
+
+	if _, err := converse(client, server, "", "user0", "password0"); err != nil {
+		t.Fatalf("login error: %s", err.Error())
+	}
+	if got, expected := serverLog.take(), strings.Join([]string{
+		"SCRAM-SHA-256 started ok",
+		"SCRAM-SHA-256 user_lookup ok",
+		"SCRAM-SHA-256 challenge ok",
+		"SCRAM-SHA-256 proof ok",
+	}, "\n"); got != expected {
+		t.Fatalf("expected server events\n%s\ngot\n%s", expected, got)
+	}
+	if got, expected := clientLog.take(), strings.Join([]string{
+		"SCRAM-SHA-256 started ok",
+		"SCRAM-SHA-256 key_derivation ok",
+		"SCRAM-SHA-256 response ok",
+		"SCRAM-SHA-256 signature ok",
+	}, "\n"); got != expected {
+		t.Fatalf("expected client events\n%s\ngot\n%s", expected, got)
+	}
+
+	converse(client, server, "", "user0", "password1")
+	if got := serverLog.take(); !strings.HasSuffix(got, "proof invalid-proof") {
+		t.Fatalf("expected a failed proof, got\n%s", got)
+	}
+	if got := clientLog.take(); !strings.HasSuffix(got, "signature invalid-proof") {
+		t.Fatalf("expected the server error, got\n%s", got)
+	}
+
+	converse(client, server, "", "nobody", "password0")
+	if got, expected := serverLog.take(), strings.Join([]string{
+		"SCRAM-SHA-256 started ok",
+		"SCRAM-SHA-256 user_lookup unknown-user",
+		"SCRAM-SHA-256 challenge unknown-user",
+	}, "\n"); got != expected {
+		t.Fatalf("expected server events\n%s\ngot\n%s", expected, got)
+	}
+}
+
+func TestExpvarObserver(t *testing.T) {
+	observer := NewExpvarObserver("scramauth_test")
+	server, _ := newTestServer(t, 1)
+	server = server.WithObserver(observer)
+	client, err := NewClient(SCRAM_SHA_256)
+	if err != nil {
+		t.Fatalf("new client error: %s", err.Error())
+	}
+	client = client.WithObserver(observer)
+	converse(client, server, "", "user0", "password0")
+	converse(client, server, "", "user0", "password1")
+
+	var vars struct {
+		Events        map[string]int
+		KeyDerivation map[string]map[string]float64 `json:"key_derivation_seconds"`
+	}
+	if err := json.Unmarshal([]byte(expvar.Get("scramauth_test").String()), &vars); err != nil {
+		t.Fatalf("unmarshal error: %s", err.Error())
+	}
+	for key, count := range map[string]int{
+		"SCRAM-SHA-256/started/ok":              4,
+		"SCRAM-SHA-256/proof/ok":                1,
+		"SCRAM-SHA-256/proof/invalid-proof":     1,
+		"SCRAM-SHA-256/signature/invalid-proof": 1,
+	} {
+		if vars.Events[key] != count {
+			t.Fatalf("expected %s to be %d, got %v", key, count, vars.Events)
+		}
+	}
+	h := vars.KeyDerivation[SCRAM_SHA_256]
+	if h["count"] != 2 || h["le_inf"] != 2 || h["sum"] <= 0 {
+		t.Fatalf("unexpected histogram %v", h)
+	}
+}
@@ -0,0 +1,55 @@
+package scramauth
+
+import (
+	"context"
+	"crypto/hmac"
+	"encoding/binary"
+	"hash"
+)
+
+// pbkdf2CheckEvery is how many iterations run between two checks of the
+// context, small enough to stop an aborted derivation within milliseconds.
+const pbkdf2CheckEvery = 1024
+
+// pbkdf2Key derives a key as in RFC 8018 section 5.2 like pbkdf2.Key, but
+// gives up with the context error as soon as ctx is done.
+func pbkdf2Key(ctx context.Context, password, salt []byte, iter, keyLen int, h func() hash.Hash) ([]byte, error) {
+	prf := hmac.New(h, password)
+	hashLen := prf.Size()
+	numBlocks := (keyLen + hashLen - 1) / hashLen
+
+	var buf [4]byte
+	dk := make([]byte, 0, numBlocks*hashLen)
+	u := make([]byte, hashLen)
+	for block := 1; block <= numBlocks; block++ {
+		if err := ctx.Err(); err != nil {
+			zero(dk)
+			return nil, err
+		}
+		prf.Reset()
+		prf.Write(salt)
+		binary.BigEndian.PutUint32(buf[:], uint32(block))
+		prf.Write(buf[:4])
+		dk = prf.Sum(dk)
+		t := dk[len(dk)-hashLen:]
+		copy(u, t)
+
+		for n := 2; n <= iter; n++ {
+			if n%pbkdf2CheckEvery == 0 {
+				if err := ctx.Err(); err != nil {
+					zero(dk)
+					return nil, err
+				}
+			}
+			prf.Reset()
+			prf.Write(u)
+			u = u[:0]
+			u = prf.Sum(u)
+			for x := range u {
+				t[x] ^= u[x]
+			}
+		}
+	}
+	zero(u)
+	return dk[:keyLen], nil
+}
@@ -0,0 +1,48 @@
+package scramauth
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"crypto/sha256"
+	"errors"
+	"hash"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+func TestPbkdf2Key(t *testing.T) {
+	for _, c := range []struct {
+		h      func() hash.Hash
+		iter   int
+		keyLen int
+	}{
+		{sha1.New, 1, 20},
+		{sha1.New, 4096, 20},
+		{sha256.New, 4096, 32},
+		{sha256.New, 3000, 50},
+	} {
+		got, err := pbkdf2Key(context.Background(), []byte("pencil"), []byte("salt"), c.iter, c.keyLen, c.h)
+		if err != nil {
+			t.Fatalf("pbkdf2 error: %s", err.Error())
+		}
+		if want := pbkdf2.Key([]byte("pencil"), []byte("salt"), c.iter, c.keyLen, c.h); !bytes.Equal(got, want) {
+			t.Fatalf("pbkdf2 mismatch for %d iterations: %x - %x", c.iter, got, want)
+		}
+	}
+}
+
+func TestPbkdf2KeyCancel(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := pbkdf2Key(ctx, []byte("pencil"), []byte("salt"), 1<<30, 32, sha256.New)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+	if time.Since(start) > time.Second {
+		t.Fatalf("cancelled derivation took %s", time.Since(start))
+	}
+}
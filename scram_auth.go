@@ -2,19 +2,19 @@ package scramauth
 
 import (
 	"bytes"
+	"context"
 	"crypto/hmac"
+	"crypto/rand"
 	"crypto/sha1"
 	"crypto/sha256"
 	"crypto/sha512"
 	"encoding/base64"
 	"errors"
+	"fmt"
 	"hash"
 	"io"
 	"math"
-	"math/rand"
-	"strconv"
 
-	"golang.org/x/crypto/pbkdf2"
 	"golang.org/x/crypto/sha3"
 )
 
@@ -39,32 +39,116 @@ const (
 	SCRAM_SHA3_512_PLUS = "SCRAM-SHA3-512-PLUS"
 )
 
+// ClientScramAuth holds the state of a single conversation. It must not be
+// reused for another login or shared between goroutines; Client spawns one
+// per login from a shared configuration.
 type ClientScramAuth struct {
 	scramAuth *scramAuth
 }
 
+// NewClientScramAuth returns a client accepting iteration counts of at
+// least 4096, the floor of RFC 5802 and RFC 7677, until
+// WithIterationPolicy says otherwise. That floor rejects every Argon2id
+// time cost and lets through scrypt costs N below 1<<14, so a client using
+// WithKDF should set WithIterationPolicy(IterationPolicyFor(mechanism)) too.
 func NewClientScramAuth(hashBuild func() hash.Hash, channelBinding CB, cbData []byte) *ClientScramAuth {
 	return &ClientScramAuth{
 		scramAuth: &scramAuth{
 			channelBinding: channelBinding,
 			cbData:         cbData,
 			hashBuild:      hashBuild,
+			iterPolicy:     IterationPolicy{Min: 4096, Max: DefaultMaxIterations},
 			gs2Header: Gs2Header{
 				Params: NewParams()}}}
 }
 
+// WithIterationPolicy sets the bounds the client enforces on the iteration
+// count sent by the server.
+func (client *ClientScramAuth) WithIterationPolicy(policy IterationPolicy) *ClientScramAuth {
+	client.scramAuth.iterPolicy = policy
+	return client
+}
+
+// WithNonceGenerator replaces the random client nonce, e.g. to replay a
+// published exchange.
+func (client *ClientScramAuth) WithNonceGenerator(gen func() ([]byte, error)) *ClientScramAuth {
+	client.scramAuth.nonce = gen
+	return client
+}
+
+// WithNonStdFlag makes the client send the gs2-nonstd-flag "F", as GS2
+// wrapped mechanisms do.
+func (client *ClientScramAuth) WithNonStdFlag() *ClientScramAuth {
+	client.scramAuth.nonStd = true
+	return client
+}
+
+// WithKeyCache makes the client store ClientKey and ServerKey in cache once
+// the server signature checks out, and use them when WriteResMsg is given
+// no password. mechanism is part of the cache key so keys of different
+// hashes never mix.
+func (client *ClientScramAuth) WithKeyCache(mechanism string, cache KeyCache) *ClientScramAuth {
+	client.scramAuth.mechanism = mechanism
+	client.scramAuth.keyCache = cache
+	return client
+}
+
+// WithFraming sets how the client finds message boundaries on the streams
+// it reads and writes, and the largest message it accepts. The default is
+// EOFFraming with DefaultMaxMessageSize.
+func (client *ClientScramAuth) WithFraming(framing Framing, maxMessageSize int) *ClientScramAuth {
+	client.scramAuth.framing = framing
+	client.scramAuth.maxMessageSize = maxMessageSize
+	return client
+}
+
 func (client *ClientScramAuth) WriteReqMsg(authzid, username string, w io.Writer) error {
 	return client.scramAuth.clientRequest(authzid, username, w)
 }
 
 func (client *ClientScramAuth) WriteResMsg(r io.Reader, password string, w io.Writer) error {
-	return client.scramAuth.clientResponse(r, password, w)
+	return client.WriteResMsgContext(context.Background(), r, password, w)
+}
+
+// WriteResMsgContext is WriteResMsg, aborting the key derivation once ctx
+// is done.
+func (client *ClientScramAuth) WriteResMsgContext(ctx context.Context, r io.Reader, password string, w io.Writer) error {
+	return client.scramAuth.clientResponse(ctx, r, password, w)
+}
+
+// Verify checks the server signature with the keys derived in WriteResMsg,
+// then wipes them.
+func (client *ClientScramAuth) Verify(r io.Reader) error {
+	return client.VerifyContext(context.Background(), r)
 }
 
-func (client *ClientScramAuth) Verify(r io.Reader, password string) error {
-	return client.scramAuth.clientVerify(r, password)
+func (client *ClientScramAuth) VerifyContext(ctx context.Context, r io.Reader) error {
+	return client.scramAuth.clientVerify(ctx, r)
 }
 
+// AuthMessage returns the AuthMessage signed by both sides, known once the
+// client-final message is written. It and the signatures below are meant
+// for debugging interop.
+func (client *ClientScramAuth) AuthMessage() []byte {
+	return client.scramAuth.authMsg()
+}
+
+// ClientSignature returns the ClientSignature of the client-final message.
+// Together with the proof it gives away ClientKey, so it is wiped with the
+// keys in Verify.
+func (client *ClientScramAuth) ClientSignature() []byte {
+	return append([]byte{}, client.scramAuth.clientSignature...)
+}
+
+// ServerSignature returns the signature the client expects from the
+// server.
+func (client *ClientScramAuth) ServerSignature() []byte {
+	return append([]byte{}, client.scramAuth.serverSignature...)
+}
+
+// ServerScramAuth holds the state of a single conversation. It must not be
+// reused for another login or shared between goroutines; Server spawns one
+// per login from a shared configuration.
 type ServerScramAuth struct {
 	scramAuth *scramAuth
 }
@@ -79,78 +163,267 @@ func NewServerScramAuth(hashBuild func() hash.Hash, channelBinding CB, cbData []
 				Params: NewParams()}}}
 }
 
+// WithUnknownUserSimulation makes the server answer unknown users with a
+// fake but stable salt derived from secret and the given iteration count,
+// so usernames can't be enumerated. The exchange then fails in Verify with
+// ErrInvalidProof just like a wrong password. FindSaltIter reports unknown
+// users by returning ErrUnknownUser.
+func (server *ServerScramAuth) WithUnknownUserSimulation(secret []byte, iter int) *ServerScramAuth {
+	server.scramAuth.simulation = &unknownUserSimulation{secret: secret, iter: iter}
+	return server
+}
+
+// WithNonceGenerator replaces the random server nonce, which the server
+// appends to the client nonce.
+func (server *ServerScramAuth) WithNonceGenerator(gen func() ([]byte, error)) *ServerScramAuth {
+	server.scramAuth.nonce = gen
+	return server
+}
+
+// WithFraming sets how the server finds message boundaries on the streams
+// it reads and writes, and the largest message it accepts. The default is
+// EOFFraming with DefaultMaxMessageSize.
+func (server *ServerScramAuth) WithFraming(framing Framing, maxMessageSize int) *ServerScramAuth {
+	server.scramAuth.framing = framing
+	server.scramAuth.maxMessageSize = maxMessageSize
+	return server
+}
+
 type FindSaltIter func(username []byte) (salt []byte, iter int, err error)
 
+// FindSaltIterContext is a FindSaltIter that receives the context of the
+// conversation, so a credential lookup can be cancelled.
+type FindSaltIterContext func(ctx context.Context, username []byte) (salt []byte, iter int, err error)
+
 func (server *ServerScramAuth) WriteChallengeMsg(r io.Reader, finder FindSaltIter, w io.Writer) error {
-	return server.scramAuth.serverChallenge(r, finder, w)
+	return server.WriteChallengeMsgContext(context.Background(), r, func(_ context.Context, username []byte) ([]byte, int, error) {
+		return finder(username)
+	}, w)
+}
+
+func (server *ServerScramAuth) WriteChallengeMsgContext(ctx context.Context, r io.Reader, finder FindSaltIterContext, w io.Writer) error {
+	return server.scramAuth.serverChallenge(ctx, r, finder, w)
 }
 
 func (server *ServerScramAuth) WriteSignatureMsg(r io.Reader, saltedPassword []byte, w io.Writer) error {
-	return server.scramAuth.serverSignature(r, saltedPassword, w)
+	return server.scramAuth.serverFinal(server.scramAuth.hmac(saltedPassword, []byte("Server Key")), w)
 }
 
 func (server *ServerScramAuth) Gs2Header() Gs2Header {
 	return server.scramAuth.gs2Header
 }
 
-func (server *ServerScramAuth) Verify(r io.Reader, storedKey []byte) error {
-	return server.scramAuth.serverVerify(r, storedKey)
+// Verify checks the client proof against the salted password. For a
+// simulated unknown user saltedPassword should be nil: Verify then derives
+// the key it checks against at the simulated iteration count, taking as
+// long as the SaltedPassword of a real user. VerifyCredential, which has
+// no key to derive for real users, derives none for simulated ones.
+func (server *ServerScramAuth) Verify(r io.Reader, saltedPassword []byte) error {
+	return server.VerifyContext(context.Background(), r, saltedPassword)
 }
 
+func (server *ServerScramAuth) VerifyContext(ctx context.Context, r io.Reader, saltedPassword []byte) error {
+	sa := server.scramAuth
+	if saltedPassword == nil && !sa.unknownUser && sa.saltedPasswordErr != nil {
+		return sa.saltedPasswordErr
+	}
+	if saltedPassword == nil && sa.unknownUser && sa.simulation != nil {
+		var err error
+		if saltedPassword, err = server.SaltedPasswordContext(ctx, sa.simulation.password(sa.username()), sa.salt, sa.iter); err != nil {
+			return err
+		}
+	}
+	var storedKey []byte
+	if saltedPassword != nil {
+		storedKey = sa.storedKey(saltedPassword)
+	}
+	return sa.serverVerify(ctx, r, storedKey)
+}
+
+// AuthMessage returns the AuthMessage signed by both sides, known once the
+// client-final message is read.
+func (server *ServerScramAuth) AuthMessage() []byte {
+	return server.scramAuth.authMsg()
+}
+
+// ClientSignature returns the ClientSignature computed in Verify. Together
+// with the proof it gives away ClientKey; don't log it in production.
+func (server *ServerScramAuth) ClientSignature() []byte {
+	return append([]byte{}, server.scramAuth.clientSignature...)
+}
+
+// ServerSignature returns the signature of the server-final message.
+func (server *ServerScramAuth) ServerSignature() []byte {
+	return append([]byte{}, server.scramAuth.serverSignature...)
+}
+
+// SaltedPassword returns nil when the derivation fails; Verify then
+// returns that error, e.g. the *OverloadedError of the admission
+// controller, rather than rejecting the proof.
+//
+// Deprecated: use SaltedPasswordContext, which returns the error.
 func (server *ServerScramAuth) SaltedPassword(password, salt []byte, iter int) []byte {
-	return server.scramAuth.saltedPassword(password, salt, iter)
+	saltedPassword, err := server.SaltedPasswordContext(context.Background(), password, salt, iter)
+	server.scramAuth.saltedPasswordErr = err
+	return saltedPassword
+}
+
+// SaltedPasswordContext is SaltedPassword, giving up with the context error
+// once ctx is done or with an *OverloadedError when the admission
+// controller turns it away.
+func (server *ServerScramAuth) SaltedPasswordContext(ctx context.Context, password, salt []byte, iter int) ([]byte, error) {
+	release, err := server.scramAuth.admit(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return server.scramAuth.saltedPassword(ctx, password, salt, iter)
 }
 
 type scramAuth struct {
 	hashBuild      func() hash.Hash
 	channelBinding CB
 	cbData         []byte
+	iterPolicy     IterationPolicy
+	simulation     *unknownUserSimulation
+	mechanism      string
+	keyCache       KeyCache
+	framing        Framing
+	maxMessageSize int
+	extensions     []Extension
+	mandatoryExt   string
+	mandatoryExts  []string
+	kdf            KDF
+	nonce          func() ([]byte, error)
+	authorize      Authorizer
+	nonStd         bool
+	observer       Observer
+	limiter        AttemptLimiter
+	remoteAddr     string
+	admission      *AdmissionController
+	replay         ReplayStore
 
 	gs2Header    Gs2Header
 	sNonce, salt []byte
 	iter         int
+	unknownUser  bool
+	attrs        [4]*Params
+
+	negotiatedKDF KDF
+
+	clientFirstBare, serverFirst, clientFinalWithoutProof []byte
+
+	clientKey, serverKey []byte
+
+	// cacheKey is where the keys derived from the password are stored
+	// once the server proved it knows them.
+	cacheKey    KeyCacheKey
+	keysToCache bool
+
+	clientSignature, serverSignature []byte
+
+	identity *Identity
+
+	// saltedPasswordErr is the error SaltedPassword couldn't return.
+	saltedPasswordErr error
 }
 
 func (sa *scramAuth) clientRequest(authzid, username string, w io.Writer) error {
+	sa.observe(EventStarted, nil)
 	p := NewParams()
+	if sa.mandatoryExt != "" {
+		p.Append(Param{Key: []byte("m"), Val: []byte(sa.mandatoryExt)})
+	}
 	p.Append([]Param{
-		{Key: []byte("n"), Val: []byte(username)},
-		{Key: []byte("r"), Val: sa.genNonce(16)},
+		{Key: []byte("n"), Val: escapeSaslname([]byte(username))},
 	}...)
+	cNonce, err := sa.genNonce()
+	if err != nil {
+		return err
+	}
+	p.Append(Param{Key: []byte("r"), Val: cNonce})
+	attrs, err := sa.writeExtensions(MsgClientFirst)
+	if err != nil {
+		return err
+	}
+	p.Append(attrs...)
+	var bare bytes.Buffer
+	if err := NewStrictEncoding(ClientFirstBareGrammar).Encode(&bare, p); err != nil {
+		return err
+	}
+	sa.clientFirstBare = bare.Bytes()
 	sa.gs2Header = Gs2Header{
+		NonStd:  sa.nonStd,
 		Authzid: []byte(authzid),
 		CB:      sa.channelBinding,
 		Params:  p}
-	return sa.gs2Header.Encode(w)
+	var buf bytes.Buffer
+	if err := sa.gs2Header.Encode(&buf); err != nil {
+		return err
+	}
+	return sa.writeMessage(w, buf.Bytes())
 }
 
-func (sa *scramAuth) serverChallenge(r io.Reader, find FindSaltIter, w io.Writer) error {
-	if err := sa.gs2Header.Decode(r); err != nil {
+func (sa *scramAuth) serverChallenge(ctx context.Context, r io.Reader, find FindSaltIterContext, w io.Writer) (err error) {
+	sa.observe(EventStarted, nil)
+	defer func() { sa.observe(EventChallenge, err) }()
+	msg, err := sa.readMessage(r)
+	if err != nil {
 		return err
 	}
-	username, ok := sa.gs2Header.Params.Val([]byte("n"))
-	if !ok {
-		return errors.New("no username found")
+	// The AuthMessage signs the bare message as the client sent it.
+	if sa.clientFirstBare, err = sa.gs2Header.decodeWith(msg, NewStrictEncoding(ClientFirstBareGrammar)); err != nil {
+		return err
+	}
+	if m, ok := sa.gs2Header.Params.Val([]byte("m")); ok && !sa.supportsMandatoryExt(string(m)) {
+		return ErrExtensionsNotSupported
+	}
+	sa.setAttrs(MsgClientFirst, sa.gs2Header.Params)
+	sa.setExtensionsUser()
+	if err := sa.readExtensions(MsgClientFirst); err != nil {
+		return err
+	}
+	username := sa.username()
+	if err := sa.allowAttempt(ctx); err != nil {
+		return err
+	}
+	sa.unknownUser = false
+	sa.salt, sa.iter, err = find(ctx, username)
+	sa.observe(EventUserLookup, err)
+	if errors.Is(err, ErrUnknownUser) && sa.simulation != nil {
+		sa.unknownUser = true
+		sa.salt, sa.iter, err = sa.simulation.salt(username), sa.simulation.iter, nil
+	} else if errors.Is(err, ErrUnknownUser) {
+		sa.recordAttempt(ctx, err)
 	}
-	var err error
-	sa.salt, sa.iter, err = find(username)
 	if err != nil {
 		return err
 	}
-	sa.sNonce = sa.genNonce(16)
-	return FullWrite(w, []byte(base64.StdEncoding.EncodeToString(sa.challengeMsg())))
+	if sa.sNonce, err = sa.genNonce(); err != nil {
+		return err
+	}
+	attrs, err := sa.writeExtensions(MsgServerFirst)
+	if err != nil {
+		return err
+	}
+	if kdf := sa.configuredKDF().Attr(); kdf != "" {
+		attrs = append([]Param{{Key: []byte{KDFAttr}, Val: []byte(kdf)}}, attrs...)
+		sa.attrs[MsgServerFirst] = NewParamsWith(attrs)
+	}
+	if sa.serverFirst, err = sa.challengeMsg(attrs); err != nil {
+		return err
+	}
+	return sa.writeMessage(w, sa.serverFirst)
 }
 
-func (sa *scramAuth) challengeMsg() []byte {
-	ps := NewParams()
-	ps.Append([]Param{
-		{Key: []byte{'r'}, Val: []byte(sa.sNonce)},
-		{Key: []byte{'s'}, Val: sa.salt},
-		{Key: []byte{'i'}, Val: []byte(strconv.Itoa(sa.iter))},
-	}...)
-	var buf bytes.Buffer
-	NewEncoding().Encode(&buf, ps)
-	return buf.Bytes()
+func (sa *scramAuth) challengeMsg(attrs []Param) ([]byte, error) {
+	cNonce, _ := sa.gs2Header.Params.Val([]byte{'r'})
+	msg := ServerFirstMessage{
+		Nonce:      append(append([]byte{}, cNonce...), sa.sNonce...),
+		Salt:       sa.salt,
+		Iterations: sa.iter,
+		Extensions: attrs}
+	return msg.MarshalText()
 }
 
 // ClientKey       := HMAC(SaltedPassword, "Client Key")
@@ -164,100 +437,107 @@ func (sa *scramAuth) challengeMsg() []byte {
 // ClientProof     := ClientKey XOR ClientSignature
 // ServerKey       := HMAC(SaltedPassword, "Server Key")
 // ServerSignature := HMAC(ServerKey, AuthMessage)
-func (sa *scramAuth) clientResponse(r io.Reader, password string, w io.Writer) error {
-	var buf bytes.Buffer
-	_, err := io.Copy(&buf, base64.NewDecoder(base64.StdEncoding, r))
+func (sa *scramAuth) clientResponse(ctx context.Context, r io.Reader, password string, w io.Writer) (err error) {
+	defer func() { sa.observe(EventResponse, err) }()
+	challenge, err := sa.readMessage(r)
 	if err != nil {
 		return err
 	}
-	sa.sNonce, sa.salt, sa.iter, err = sa.rsi(buf.String())
+	nonce, salt, iter, p, err := sa.rsi(challenge)
 	if err != nil {
 		return err
 	}
-	authMsg, err := sa.authMsg()
-	if err != nil {
+	cNonce, _ := sa.gs2Header.Params.Val([]byte{'r'})
+	if len(nonce) <= len(cNonce) || !bytes.HasPrefix(nonce, cNonce) {
+		return errors.New("server nonce doesn't extend the client nonce")
+	}
+	sa.sNonce, sa.salt, sa.iter = nonce[len(cNonce):], salt, iter
+	sa.serverFirst = challenge
+	kdf, _ := p.Val([]byte{KDFAttr})
+	if sa.negotiatedKDF, err = sa.configuredKDF().Negotiate(string(kdf), sa.iter); err != nil {
 		return err
 	}
-	saltedPassword := sa.saltedPassword([]byte(password), []byte(sa.salt), sa.iter)
-	clientKey := sa.hmac(saltedPassword, []byte("Client Key"))
-	storedKey := sa.hash(clientKey)
-	signature := sa.hmac(storedKey, authMsg)
-	if err != nil {
+	sa.setAttrs(MsgServerFirst, p)
+	if err := sa.readExtensions(MsgServerFirst); err != nil {
 		return err
 	}
-	clientProof := sa.xor(clientKey, signature)
-	out, err := sa.clientFinalMsgWithoutProof(sa.sNonce)
+	attrs, err := sa.writeExtensions(MsgClientFinal)
 	if err != nil {
 		return err
 	}
-	out = append(out, ",p="...)
-	out = append(out, []byte(base64.StdEncoding.EncodeToString(clientProof))...)
-	_, err = w.Write(out)
-	return err
-}
-
-func (sa *scramAuth) authMsg() ([]byte, error) {
-	fmb, err := sa.clientFirstMsgBare()
-	if err != nil {
-		return []byte{}, err
+	if sa.clientFinalWithoutProof, err = sa.clientFinalMsgWithoutProof(sa.sNonce, attrs); err != nil {
+		return err
 	}
-	fmwp, err := sa.clientFinalMsgWithoutProof(sa.sNonce)
+	authMsg := sa.authMsg()
+	keys, err := sa.clientKeys(ctx, password)
 	if err != nil {
-		return []byte{}, err
+		return err
 	}
-	var buf bytes.Buffer
-	p := NewParams()
-	p.Append([]Param{
-		{Key: []byte(fmb)},
-		{Key: []byte(sa.challengeMsg())},
-		{Key: []byte(fmwp)},
-	}...)
-	err = NewEncoding().Encode(&buf, p)
-	return buf.Bytes(), err
+	sa.clearKeys()
+	sa.clientKey, sa.serverKey = keys.ClientKey, keys.ServerKey
+	storedKey := sa.hash(sa.clientKey)
+	signature := sa.hmac(storedKey, authMsg)
+	sa.clientSignature = signature
+	sa.serverSignature = sa.hmac(sa.serverKey, authMsg)
+	clientProof := sa.xor(sa.clientKey, signature)
+	out := append([]byte{}, sa.clientFinalWithoutProof...)
+	out = append(out, ",p="...)
+	out = append(out, base64.StdEncoding.EncodeToString(clientProof)...)
+	return sa.writeMessage(w, out)
+}
+
+// authMsg joins the messages as they were sent, followed by the data of
+// extensions contributing to the AuthMessage.
+func (sa *scramAuth) authMsg() []byte {
+	out := append([]byte{}, sa.clientFirstBare...)
+	out = append(out, ',')
+	out = append(out, sa.serverFirst...)
+	out = append(out, ',')
+	out = append(out, sa.clientFinalWithoutProof...)
+	for _, ext := range sa.extensions {
+		if c, ok := ext.(AuthMessageContributor); ok {
+			out = append(out, ',')
+			out = append(out, c.AuthMessageData()...)
+		}
+	}
+	return out
 }
 
-func (sa *scramAuth) rsi(msg string) (r, s []byte, i int, err error) {
-	rd := bytes.NewBuffer([]byte(msg))
-	p := NewParams()
-	if err = NewEncoding().Decode(rd, p); err != nil {
-		return
-	}
-	if len(p.All()) < 3 {
-		err = errors.New("incorrect challenge format")
-		return
-	}
-	var ok bool
-	if r, ok = p.Val([]byte{'r'}); !ok {
-		err = errors.New("incorrect challenge format")
+func (sa *scramAuth) rsi(msg []byte) (r, s []byte, i int, p *Params, err error) {
+	var serverFirst ServerFirstMessage
+	if err = serverFirst.UnmarshalText(msg); err != nil {
 		return
 	}
-	if s, ok = p.Val([]byte{'s'}); !ok {
-		err = errors.New("incorrect challenge format")
-		return
-	}
-	var ti []byte
-	if ti, ok = p.Val([]byte{'i'}); !ok {
-		err = errors.New("incorrect challenge format")
-		return
-	}
-	i, err = strconv.Atoi(string(ti))
+	r, s, i = serverFirst.Nonce, serverFirst.Salt, serverFirst.Iterations
+	p = NewParamsWith(serverFirst.Extensions)
+	err = sa.iterPolicy.check(i)
 	return
 }
 
-func (sa *scramAuth) clientFirstMsgBare() ([]byte, error) {
-	out := []byte("n=")
-	if n, ok := sa.gs2Header.Params.Val([]byte("n")); ok {
-		out = append(out, n...)
-	} else {
-		return []byte{}, errors.New("error occured")
+// readMessage reads a single message off r, bounded by the configured
+// maximum message size.
+func (sa *scramAuth) readMessage(r io.Reader) ([]byte, error) {
+	max := sa.maxMessageSize
+	if max <= 0 {
+		max = DefaultMaxMessageSize
 	}
-	out = append(out, ",r="...)
-	if r, ok := sa.gs2Header.Params.Val([]byte("r")); ok {
-		out = append(out, r...)
-	} else {
-		return []byte{}, errors.New("error occured")
+	if sa.framing == nil {
+		return EOFFraming{}.ReadMessage(r, max)
 	}
-	return out, nil
+	return sa.framing.ReadMessage(r, max)
+}
+
+func (sa *scramAuth) writeMessage(w io.Writer, msg []byte) error {
+	if sa.framing == nil {
+		return EOFFraming{}.WriteMessage(w, msg)
+	}
+	return sa.framing.WriteMessage(w, msg)
+}
+
+// username returns the unescaped username of the client-first message.
+func (sa *scramAuth) username() []byte {
+	n, _ := sa.gs2Header.Params.Val([]byte("n"))
+	return unescapeSaslname(n)
 }
 
 func (sa *scramAuth) xor(a, b []byte) []byte {
@@ -269,139 +549,281 @@ func (sa *scramAuth) xor(a, b []byte) []byte {
 	return out
 }
 
-func (sa *scramAuth) clientFinalMsgWithoutProof(sNonce []byte) ([]byte, error) {
+// channelBindingInput is the decoded c attribute of the client-final message:
+// the gs2-header without the "F" flag followed by the channel binding data
+// when the client uses channel binding.
+func (sa *scramAuth) channelBindingInput() []byte {
+	input := sa.gs2Header.cbindHeader()
+	if sa.gs2Header.CB != None {
+		input = append(input, sa.cbData...)
+	}
+	return input
+}
+
+func (sa *scramAuth) clientFinalMsgWithoutProof(sNonce []byte, attrs []Param) ([]byte, error) {
 	cNonce, ok := sa.gs2Header.Params.Val([]byte("r"))
 	if !ok {
 		return []byte{}, errors.New("invalid gs2 header")
 	}
-	nonce := append(cNonce, sNonce...)
-	out := []byte("c=")
-	switch sa.channelBinding {
-	case None:
-		out = append(out, []byte("biws")...)
-	case TlsUnique:
-		out = append(out, []byte(base64.StdEncoding.EncodeToString(sa.cbData))...)
+	msg := ClientFinalMessage{
+		ChannelBinding: sa.channelBindingInput(),
+		Nonce:          append(append([]byte{}, cNonce...), sNonce...),
+		Extensions:     attrs}
+	return msg.withoutProof()
+}
+
+func (sa *scramAuth) serverFinal(serverKey []byte, w io.Writer) error {
+	authMsg := sa.authMsg()
+	signature := sa.hmac(serverKey, authMsg)
+	sa.serverSignature = signature
+	attrs, err := sa.writeExtensions(MsgServerFinal)
+	if err != nil {
+		return err
 	}
-	if sa.channelBinding != None {
-	} else {
+	msg, err := (&ServerFinalMessage{Verifier: signature, Extensions: attrs}).MarshalText()
+	if err != nil {
+		return err
 	}
-	out = append(out, ",r="...)
-	out = append(out, nonce...)
-	return out, nil
+	return sa.writeMessage(w, msg)
 }
 
-func (sa *scramAuth) serverSignature(r io.Reader, saltedPassword []byte, w io.Writer) error {
-	authMsg, err := sa.authMsg()
+func (sa *scramAuth) serverError(err error, w io.Writer) error {
+	msg, err := (&ServerFinalMessage{Error: serverErrorOf(err)}).MarshalText()
 	if err != nil {
 		return err
 	}
-	serverKey := sa.hmac(saltedPassword, []byte("Server Key"))
-
-	signature := sa.hmac(serverKey, authMsg)
-	p := NewParams()
-	p.Append(Param{Key: []byte{'v'}, Val: []byte(base64.StdEncoding.EncodeToString(signature))})
-
-	return NewEncoding().Encode(w, p)
+	return sa.writeMessage(w, msg)
 }
 
-func (sa *scramAuth) clientVerify(r io.Reader, password string) error {
-	authMsg, err := sa.authMsg()
+func (sa *scramAuth) clientVerify(ctx context.Context, r io.Reader) (err error) {
+	defer func() { sa.observe(EventSignature, err) }()
+	defer sa.clearKeys()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if sa.serverKey == nil {
+		return errors.New("no keys derived, client response not written")
+	}
+	msg, err := sa.readMessage(r)
 	if err != nil {
 		return err
 	}
-	serverKey := sa.hmac(sa.saltedPassword([]byte(password), sa.salt, sa.iter), []byte("Server Key"))
 	p := NewParams()
-	if err := NewEncoding().Decode(r, p); err != nil {
+	if err := NewStrictEncoding(ServerFinalGrammar).Parse(msg, p); err != nil {
 		return err
 	}
-	ssb, ok := p.Val([]byte{'v'})
-	if !ok {
-		return errors.New("server signature not found")
+	sa.setAttrs(MsgServerFinal, p)
+	if err := sa.readExtensions(MsgServerFinal); err != nil {
+		return err
+	}
+	if e, ok := p.Val([]byte{'e'}); ok {
+		if ServerError(e) == ErrInvalidProof {
+			sa.uncacheKeys()
+		}
+		return ServerError(e)
 	}
+	ssb, _ := p.Val([]byte{'v'})
 	ss, err := base64.StdEncoding.DecodeString(string(ssb))
 	if err != nil {
 		return err
 	}
-	if !bytes.Equal(ss, sa.hmac(serverKey, authMsg)) {
-		return errors.New("failed")
+	if !hmac.Equal(ss, sa.serverSignature) {
+		sa.uncacheKeys()
+		return ErrServerSignature
+	}
+	if sa.keyCache != nil && sa.keysToCache {
+		sa.keyCache.Put(sa.cacheKey, CachedKeys{ClientKey: sa.clientKey, ServerKey: sa.serverKey})
 	}
 	return nil
 }
 
-func (sa *scramAuth) serverVerify(r io.Reader, saltedPassword []byte) error {
-	if sa.channelBinding != sa.gs2Header.CB {
-		return errors.New("channel binding type not match")
+// uncacheKeys drops the cached keys the server rejected.
+func (sa *scramAuth) uncacheKeys() {
+	if sa.keyCache != nil {
+		sa.keyCache.Delete(sa.cacheKey)
 	}
-	p := NewParams()
-	if err := NewEncoding().Decode(r, p); err != nil {
+}
+
+func (sa *scramAuth) serverVerify(ctx context.Context, r io.Reader, storedKey []byte) (err error) {
+	defer func() {
+		sa.observe(EventProof, err)
+	}()
+	if err := ctx.Err(); err != nil {
 		return err
 	}
-	authMsg, err := sa.authMsg()
+	if err := sa.allowAttempt(ctx); err != nil {
+		return err
+	}
+	release, err := sa.admit(ctx)
 	if err != nil {
 		return err
 	}
-	clientKey := sa.hmac(saltedPassword, []byte("Client Key"))
-	storedKey := sa.hash(clientKey)
-	signature := sa.hmac(storedKey, authMsg)
-	pr, ok := p.Val([]byte{'p'})
-	if !ok {
-		return errors.New("invalid client message")
+	defer release()
+	if sa.gs2Header.supportsCB && sa.channelBinding != None {
+		return ErrServerDoesSupportChannelBinding
+	}
+	if sa.channelBinding != sa.gs2Header.CB {
+		switch {
+		case sa.channelBinding == None:
+			return ErrChannelBindingNotSupported
+		case sa.gs2Header.CB == None:
+			return ErrChannelBindingsDontMatch
+		}
+		return ErrUnsupportedChannelBindingType
+	}
+	msg, err := sa.readMessage(r)
+	if err != nil {
+		return err
 	}
+	p := NewParams()
+	if err := NewStrictEncoding(ClientFinalGrammar).Parse(msg, p); err != nil {
+		return err
+	}
+	if c, _ := p.Val([]byte{'c'}); string(c) != base64.StdEncoding.EncodeToString(sa.channelBindingInput()) {
+		return ErrChannelBindingsDontMatch
+	}
+	cNonce, _ := sa.gs2Header.Params.Val([]byte{'r'})
+	if n, _ := p.Val([]byte{'r'}); !bytes.Equal(n, append(append([]byte{}, cNonce...), sa.sNonce...)) {
+		return fmt.Errorf("%w: nonce mismatch", ErrOtherError)
+	}
+	pr, _ := p.Val([]byte{'p'})
+	sa.clientFinalWithoutProof = msg[:len(msg)-len(",p=")-len(pr)]
+	sa.setAttrs(MsgClientFinal, p)
+	authMsg := sa.authMsg()
+	signature := sa.hmac(storedKey, authMsg)
+	sa.clientSignature = signature
 	proof, err := base64.StdEncoding.DecodeString(string(pr))
 	if err != nil {
 		return err
 	}
-	clientKey = sa.xor(signature, proof)
+	clientKey := sa.xor(signature, proof)
 	attemptingStoredKey := sa.hash(clientKey)
 
-	if bytes.Equal(attemptingStoredKey, storedKey) {
-		return nil
+	if len(storedKey) == 0 && !sa.unknownUser {
+		// No key to check against is the caller's failure, not the user's.
+		return ErrInvalidProof
+	}
+	if !hmac.Equal(attemptingStoredKey, storedKey) || sa.unknownUser {
+		sa.recordAttempt(ctx, ErrInvalidProof)
+		return ErrInvalidProof
+	}
+	// A valid proof only resets the counters once the second factor and
+	// the authzid passed too, or the OTP could be guessed freely.
+	if err := sa.readExtensions(MsgClientFinal); err != nil {
+		if errors.Is(err, ErrInvalidProof) {
+			sa.recordAttempt(ctx, err)
+		}
+		return err
+	}
+	if err := sa.authorizeUser(); err != nil {
+		sa.recordAttempt(ctx, err)
+		return err
 	}
+	sa.recordAttempt(ctx, nil)
+	return nil
+}
+
+// clientKeys derives ClientKey and ServerKey from password or, when no
+// password is given, takes them from the key cache. Derived keys are
+// cached by clientVerify once the server signature checks out. The
+// returned keys are owned by the caller, who wipes them once the
+// conversation completes.
+func (sa *scramAuth) clientKeys(ctx context.Context, password string) (CachedKeys, error) {
+	sa.keysToCache = false
+	if sa.keyCache != nil {
+		sa.cacheKey = KeyCacheKey{
+			Mechanism:  sa.mechanism,
+			Username:   string(sa.username()),
+			Salt:       string(sa.salt),
+			Iterations: sa.iter,
+			KDF:        sa.conversationKDF().Attr()}
+		if password == "" {
+			keys, ok := sa.keyCache.Get(sa.cacheKey)
+			if !ok {
+				return CachedKeys{}, ErrKeysNotCached
+			}
+			return keys, nil
+		}
+	}
+	saltedPassword, err := sa.saltedPassword(ctx, []byte(password), sa.salt, sa.iter)
+	if err != nil {
+		return CachedKeys{}, err
+	}
+	keys := CachedKeys{
+		ClientKey: sa.hmac(saltedPassword, []byte("Client Key")),
+		ServerKey: sa.hmac(saltedPassword, []byte("Server Key"))}
+	zero(saltedPassword)
+	sa.keysToCache = true
+	return keys, nil
+}
 
-	return errors.New("failed")
+// clearKeys wipes the keys the client derived for this conversation.
+func (sa *scramAuth) clearKeys() {
+	zero(sa.clientKey)
+	zero(sa.serverKey)
+	zero(sa.clientSignature)
+	sa.clientKey, sa.serverKey, sa.clientSignature = nil, nil, nil
 }
 
-func (sa *scramAuth) saltedPassword(password, salt []byte, iter int) []byte {
-	return sa.hi(sa.normalizePassword(password), salt, iter)
+func (sa *scramAuth) saltedPassword(ctx context.Context, password, salt []byte, iter int) ([]byte, error) {
+	return sa.hi(ctx, sa.normalizePassword(password), salt, iter)
 }
 
-func (scram *scramAuth) hi(str, salt []byte, iter int) []byte {
+func (scram *scramAuth) hi(ctx context.Context, str, salt []byte, iter int) ([]byte, error) {
 	l := scram.hashBuild().Size()
-	return pbkdf2.Key(str, salt, iter, l, scram.hashBuild)
+	start := now()
+	key, err := scram.conversationKDF().Key(ctx, str, salt, iter, l)
+	if scram.observer != nil {
+		scram.observer.Observe(Event{Kind: EventKeyDerivation, Mechanism: scram.mechanism, Err: err, Duration: now().Sub(start)})
+	}
+	return key, err
 }
 
 func (sa *scramAuth) normalizePassword(password []byte) []byte {
 	return password
 }
 
-func (scram *scramAuth) hmac(b []byte, key []byte) []byte {
+// hmac is HMAC(key, str) of RFC 5802.
+func (scram *scramAuth) hmac(key, str []byte) []byte {
 	m := hmac.New(scram.hashBuild, key)
-	m.Write(b)
+	m.Write(str)
 	return m.Sum(nil)
 }
 
+func (sa *scramAuth) storedKey(saltedPassword []byte) []byte {
+	return sa.hash(sa.hmac(saltedPassword, []byte("Client Key")))
+}
+
 func (scram *scramAuth) hash(b []byte) []byte {
 	h := scram.hashBuild()
 	h.Write(b)
 	return h.Sum(nil)
 }
 
-func (scram *scramAuth) genNonce(n int) []byte {
-	var letters = []byte("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ")
-	b := make([]byte, n)
-	for i := range b {
-		b[i] = letters[rand.Intn(len(letters))]
+// genNonce returns the nonce of the injected generator, or 18 random bytes
+// in base64, which is printable and free of commas.
+func (sa *scramAuth) genNonce() ([]byte, error) {
+	if sa.nonce != nil {
+		return sa.nonce()
+	}
+	b := make([]byte, 18)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
 	}
-	return b
+	return []byte(base64.StdEncoding.EncodeToString(b)), nil
 }
 
 func HashBuild(mechanism string) func() hash.Hash {
 	switch mechanism {
 	case SCRAM_SHA_1, SCRAM_SHA_1_PLUS:
 		return sha1.New
-	case SCRAM_SHA_256, SCRAM_SHA_256_PLUS:
+	case SCRAM_SHA_256, SCRAM_SHA_256_PLUS,
+		X_SCRAM_ARGON2ID_SHA_256, X_SCRAM_ARGON2ID_SHA_256_PLUS,
+		X_SCRAM_SCRYPT_SHA_256, X_SCRAM_SCRYPT_SHA_256_PLUS:
 		return sha256.New
-	case SCRAM_SHA_512, SCRAM_SHA_512_PLUS:
+	case SCRAM_SHA_512, SCRAM_SHA_512_PLUS,
+		X_SCRAM_ARGON2ID_SHA_512, X_SCRAM_ARGON2ID_SHA_512_PLUS,
+		X_SCRAM_SCRYPT_SHA_512, X_SCRAM_SCRYPT_SHA_512_PLUS:
 		return sha512.New
 	case SCRAM_SHA_224, SCRAM_SHA_224_PLUS:
 		return sha256.New224
@@ -2,11 +2,14 @@ package scramauth
 
 import (
 	"bytes"
+	"context"
 	"crypto/sha1"
 	"crypto/sha256"
-	"encoding/base64"
+	"errors"
 	"fmt"
+	"io"
 	"testing"
+	"time"
 )
 
 func TestClientWriteReqMsg(t *testing.T) {
@@ -17,33 +20,24 @@ func TestClientWriteReqMsg(t *testing.T) {
 	}
 	cnonce, _ := auth.scramAuth.gs2Header.Params.Val([]byte{'r'})
 	rsc := fmt.Sprintf("p=tls-unique,a=hello-world,n=yang-zhong,r=%s", cnonce)
-	res := base64.StdEncoding.EncodeToString([]byte(rsc))
-	if res != buf.String() {
-		t.Logf("\n%s\n%s\n", res, buf.String())
-		rr, _ := base64.StdEncoding.DecodeString(buf.String())
-		t.Logf("\n%s\n%s\n", rsc, rr)
+	if rsc != buf.String() {
+		t.Logf("\n%s\n%s\n", rsc, buf.String())
 		t.Fatalf("client start error")
 	}
 }
 
 func TestServerChallenge(t *testing.T) {
 	auth := NewServerScramAuth(sha1.New, TlsUnique, []byte{'1', '2', '3'})
-	cnonce, _ := auth.scramAuth.gs2Header.Params.Val([]byte{'r'})
-	input := base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("p=tls-unique,a=hello-world,n=yang-zhong,r=%s", cnonce)))
-	buf := bytes.NewBuffer([]byte(input))
+	buf := bytes.NewBufferString("p=tls-unique,a=hello-world,n=yang-zhong,r=fyko+d2lbbFgONRv9qkxdawL")
 	var res bytes.Buffer
 	if err := auth.WriteChallengeMsg(buf, func(username []byte) (salt []byte, iter int, err error) {
 		return []byte("12345678"), 4, nil
 	}, &res); err != nil {
 		t.Fatalf("write challenge msg error: %s", err.Error())
 	}
-	rr := fmt.Sprintf("r=%s,s=%s,i=%d", auth.scramAuth.sNonce, auth.scramAuth.salt, auth.scramAuth.iter)
-	rrr := base64.StdEncoding.EncodeToString([]byte(rr))
-	if res.String() != rrr {
-		rrrr, err := base64.StdEncoding.DecodeString(res.String())
-		if err == nil {
-			t.Logf("%s - %s\n", rrrr, rr)
-		}
+	rr := fmt.Sprintf("r=fyko+d2lbbFgONRv9qkxdawL%s,s=MTIzNDU2Nzg=,i=%d", auth.scramAuth.sNonce, auth.scramAuth.iter)
+	if res.String() != rr {
+		t.Logf("%s - %s\n", res.String(), rr)
 		t.Fatalf("server challenge error")
 	}
 }
@@ -55,11 +49,12 @@ func TestAuth(t *testing.T) {
 	if err := auth1.WriteReqMsg("hello-world", "yang-zhong", &rmb); err != nil {
 		t.Fatalf("write req msg error: %s", err.Error())
 	}
-	auth2 := NewServerScramAuth(sha256.New, TlsUnique, []byte{'1', '2', '3'})
+	auth2 := NewServerScramAuth(sha256.New, TlsUnique, []byte{'1', '2', '3'}).
+		WithAuthorizer(func(authcid, authzid string) error { return nil })
 	// generate server first message
 	var cmb bytes.Buffer
 	if err := auth2.WriteChallengeMsg(&rmb, func(username []byte) (salt []byte, iter int, err error) {
-		return []byte("12345678"), 4, nil
+		return []byte("12345678"), 4096, nil
 	}, &cmb); err != nil {
 		t.Fatalf("write challenge msg error: %s", err.Error())
 	}
@@ -68,7 +63,7 @@ func TestAuth(t *testing.T) {
 	if e := auth1.WriteResMsg(&cmb, "123456", &crb); e != nil {
 		t.Fatalf("client response error: %s", e.Error())
 	}
-	saltedPassword := auth2.SaltedPassword([]byte("123456"), []byte("12345678"), 4)
+	saltedPassword := auth2.SaltedPassword([]byte("123456"), []byte("12345678"), 4096)
 	err := auth2.Verify(&crb, saltedPassword)
 	if err != nil {
 		t.Fatalf("server verify error: %s", err.Error())
@@ -77,7 +72,118 @@ func TestAuth(t *testing.T) {
 	if err := auth2.WriteSignatureMsg(&crb, saltedPassword, &smb); err != nil {
 		t.Fatalf("server signature error: %s", err.Error())
 	}
-	if err := auth1.Verify(&smb, "123456"); err != nil {
+	if err := auth1.Verify(&smb); err != nil {
 		t.Fatalf("client verify error: %s", err.Error())
 	}
+	if auth1.scramAuth.clientKey != nil || auth1.scramAuth.serverKey != nil {
+		t.Fatalf("client keys not wiped after verify")
+	}
+}
+
+func TestClientVerifyWithoutResponse(t *testing.T) {
+	auth := NewClientScramAuth(sha256.New, None, nil)
+	if err := auth.Verify(bytes.NewBufferString("v=")); err == nil {
+		t.Fatalf("verify without response should fail")
+	}
+}
+
+type ctxKey struct{}
+
+func TestServerChallengeContext(t *testing.T) {
+	client := NewClientScramAuth(sha256.New, None, nil)
+	var req bytes.Buffer
+	if err := client.WriteReqMsg("", "yang-zhong", &req); err != nil {
+		t.Fatalf("write req msg error: %s", err.Error())
+	}
+	server := NewServerScramAuth(sha256.New, None, nil)
+	ctx := context.WithValue(context.Background(), ctxKey{}, "request")
+	var challenge bytes.Buffer
+	if err := server.WriteChallengeMsgContext(ctx, &req, func(ctx context.Context, username []byte) ([]byte, int, error) {
+		if ctx.Value(ctxKey{}) != "request" {
+			t.Fatalf("finder didn't receive the conversation context")
+		}
+		return []byte("12345678"), 1 << 30, nil
+	}, &challenge); err != nil {
+		t.Fatalf("write challenge msg error: %s", err.Error())
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	client.WithIterationPolicy(IterationPolicy{Max: 1 << 30})
+	if err := client.WriteResMsgContext(ctx, &challenge, "123456", &bytes.Buffer{}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := server.SaltedPasswordContext(cancelled, []byte("123456"), []byte("12345678"), 4096); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context canceled, got %v", err)
+	}
+}
+
+// serverKeys is the server's side of a user in runExchange: the salt and
+// iteration count it announces, and how it checks the client proof and
+// signs the server-final message.
+type serverKeys struct {
+	salt   []byte
+	iter   int
+	verify func(server *ServerScramAuth, r io.Reader) error
+	sign   func(server *ServerScramAuth, w io.Writer) error
+}
+
+// passwordKeys salts the password 123456 with salt and iter on every login.
+func passwordKeys(salt string, iter int) serverKeys {
+	var saltedPassword []byte
+	return serverKeys{
+		salt: []byte(salt),
+		iter: iter,
+		verify: func(server *ServerScramAuth, r io.Reader) error {
+			saltedPassword = server.SaltedPassword([]byte("123456"), []byte(salt), iter)
+			return server.Verify(r, saltedPassword)
+		},
+		sign: func(server *ServerScramAuth, w io.Writer) error {
+			return server.WriteSignatureMsg(nil, saltedPassword, w)
+		},
+	}
+}
+
+// credentialKeys checks logins against the stored keys of cred.
+func credentialKeys(cred *Credential) serverKeys {
+	return serverKeys{
+		salt: cred.Salt,
+		iter: cred.Iterations,
+		verify: func(server *ServerScramAuth, r io.Reader) error {
+			return server.VerifyCredential(r, cred)
+		},
+		sign: func(server *ServerScramAuth, w io.Writer) error {
+			return server.WriteCredentialSignatureMsg(cred, w)
+		},
+	}
+}
+
+// runExchange runs a full conversation between client and server for a
+// user with the given keys, stopping at the first error. A failing server
+// verification is reported to the client.
+func runExchange(client *ClientScramAuth, server *ServerScramAuth, password string, keys serverKeys) error {
+	var req bytes.Buffer
+	if err := client.WriteReqMsg("", "yang-zhong", &req); err != nil {
+		return err
+	}
+	var challenge bytes.Buffer
+	if err := server.WriteChallengeMsg(&req, func(username []byte) ([]byte, int, error) {
+		return keys.salt, keys.iter, nil
+	}, &challenge); err != nil {
+		return err
+	}
+	var res bytes.Buffer
+	if err := client.WriteResMsg(&challenge, password, &res); err != nil {
+		return err
+	}
+	var final bytes.Buffer
+	if err := keys.verify(server, &res); err != nil {
+		if e := server.WriteErrorMsg(err, &final); e != nil {
+			return e
+		}
+	} else if err := keys.sign(server, &final); err != nil {
+		return err
+	}
+	return client.Verify(&final)
 }
@@ -0,0 +1,62 @@
+package scramauth
+
+import (
+	"errors"
+	"io"
+)
+
+// ServerError is a server-error-value of RFC 5802, sent to the client as
+// the e attribute of the server-final message and returned by the client
+// when it receives one.
+type ServerError string
+
+func (e ServerError) Error() string {
+	return string(e)
+}
+
+const (
+	ErrInvalidEncoding                 = ServerError("invalid-encoding")
+	ErrExtensionsNotSupported          = ServerError("extensions-not-supported")
+	ErrInvalidProof                    = ServerError("invalid-proof")
+	ErrChannelBindingsDontMatch        = ServerError("channel-bindings-dont-match")
+	ErrServerDoesSupportChannelBinding = ServerError("server-does-support-channel-binding")
+	ErrChannelBindingNotSupported      = ServerError("channel-binding-not-supported")
+	ErrUnsupportedChannelBindingType   = ServerError("unsupported-channel-binding-type")
+	ErrInvalidUsernameEncoding         = ServerError("invalid-username-encoding")
+	ErrNoResources                     = ServerError("no-resources")
+	ErrOtherError                      = ServerError("other-error")
+	// ErrUnknownUser is also returned by a FindSaltIter when the username
+	// doesn't exist. With unknown-user simulation enabled the server keeps
+	// the exchange going instead of failing the challenge.
+	ErrUnknownUser = ServerError("unknown-user")
+)
+
+// ErrServerSignature is returned by the client when the server signature
+// doesn't match, i.e. the server doesn't know the user's keys.
+var ErrServerSignature = errors.New("invalid server signature")
+
+// serverErrorOf maps err to the value sent to the client.
+func serverErrorOf(err error) ServerError {
+	var se ServerError
+	if errors.As(err, &se) {
+		return se
+	}
+	var ae *AttributeError
+	if errors.As(err, &ae) && (ae.Attr == "n" || ae.Attr == "a") {
+		return ErrInvalidUsernameEncoding
+	}
+	var tl *MessageTooLargeError
+	if errors.As(err, &ae) || errors.As(err, &tl) {
+		return ErrInvalidEncoding
+	}
+	return ErrOtherError
+}
+
+// WriteErrorMsg writes a server-final message reporting err to the client
+// as an e attribute. Errors other than ServerError are reported as
+// invalid-encoding when the client sent a malformed message,
+// invalid-username-encoding when that was the username or authzid, and as
+// other-error otherwise, so no detail leaks to the client.
+func (server *ServerScramAuth) WriteErrorMsg(err error, w io.Writer) error {
+	return server.scramAuth.serverError(err, w)
+}
@@ -0,0 +1,38 @@
+package scramauth
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestServerErrorOf(t *testing.T) {
+	for _, c := range []struct {
+		err  error
+		want ServerError
+	}{
+		{ErrInvalidProof, ErrInvalidProof},
+		{fmt.Errorf("%w: nonce mismatch", ErrOtherError), ErrOtherError},
+		{&AttributeError{Attr: "r", Reason: "empty value"}, ErrInvalidEncoding},
+		{&AttributeError{Attr: "n", Reason: "invalid UTF-8"}, ErrInvalidUsernameEncoding},
+		{&MessageTooLargeError{Limit: 10}, ErrInvalidEncoding},
+		{errors.New("database is down"), ErrOtherError},
+		{&LockedError{RetryAfter: time.Second}, ErrOtherError},
+	} {
+		if got := serverErrorOf(c.err); got != c.want {
+			t.Fatalf("%v: expected %s, got %s", c.err, c.want, got)
+		}
+	}
+}
+
+func TestClientReceivesServerError(t *testing.T) {
+	client := NewClientScramAuth(sha256.New, None, nil)
+	server := NewServerScramAuth(sha256.New, None, nil)
+	err := runExchange(client, server, "wrong", passwordKeys("12345678", 4096))
+	var se ServerError
+	if !errors.As(err, &se) || se != ErrInvalidProof {
+		t.Fatalf("expected invalid-proof from the server, got %v", err)
+	}
+}
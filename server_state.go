@@ -0,0 +1,214 @@
+package scramauth
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+)
+
+const stateVersion = 1
+
+var (
+	// ErrInvalidState is returned when a state token can't be opened,
+	// because it was tampered with or sealed with another key.
+	ErrInvalidState = errors.New("invalid conversation state")
+	// ErrStateExpired is returned when a state token is past its expiry.
+	ErrStateExpired = errors.New("conversation state expired")
+	// ErrStateReplayed is returned when a state token was resumed before.
+	ErrStateReplayed = errors.New("conversation state already resumed")
+)
+
+var now = time.Now
+
+// serverState is what a server needs between WriteChallengeMsg and Verify.
+type serverState struct {
+	ID          []byte  `json:"id"`
+	NonStd      bool    `json:"f,omitempty"`
+	CB          CB      `json:"cb"`
+	Authzid     []byte  `json:"a,omitempty"`
+	SupportsCB  bool    `json:"y,omitempty"`
+	Params      []Param `json:"p"`
+	SNonce      []byte  `json:"r"`
+	Salt        []byte  `json:"s"`
+	Iter        int     `json:"i"`
+	UnknownUser bool    `json:"u,omitempty"`
+	// ClientFirstBare and ServerFirst are the messages as sent, for the
+	// AuthMessage.
+	ClientFirstBare []byte `json:"cfb"`
+	ServerFirst     []byte `json:"sf"`
+	Expires         int64  `json:"exp"`
+}
+
+// ExportState seals the in-flight conversation into an opaque token,
+// encrypted and authenticated with key (16, 24 or 32 bytes for AES-128,
+// AES-192 or AES-256) and valid for ttl. The token is URL safe, so it can
+// travel in an HTTP sid or a cookie and be resumed with ResumeState by
+// any server sharing key. Each token carries a one-time ID and can be
+// resumed once: servers resuming each other's tokens must share a
+// ReplayStore, see WithReplayStore.
+func (server *ServerScramAuth) ExportState(key []byte, ttl time.Duration) (string, error) {
+	return server.scramAuth.exportState(key, ttl)
+}
+
+// ResumeState restores a conversation exported with ExportState, so Verify
+// and WriteSignatureMsg can run on this server. State kept by extensions
+// isn't part of the token, UserExtensions are given the user again. A
+// token resumed before fails with ErrStateReplayed.
+func (server *ServerScramAuth) ResumeState(key []byte, token string) error {
+	return server.scramAuth.resumeState(key, token)
+}
+
+// WithReplayStore sets where the IDs of resumed tokens are recorded. The
+// default is an in-memory store shared by the process, which doesn't
+// protect tokens resumed by other processes.
+func (server *ServerScramAuth) WithReplayStore(store ReplayStore) *ServerScramAuth {
+	server.scramAuth.replay = store
+	return server
+}
+
+// ReplayStore records the IDs of resumed state tokens.
+type ReplayStore interface {
+	// Consume records id until expires, when the token expires, and
+	// returns ErrStateReplayed when id was recorded before.
+	Consume(id string, expires time.Time) error
+}
+
+// MemoryReplayStore is an in-memory ReplayStore safe for concurrent use.
+// IDs are dropped once their token expired.
+type MemoryReplayStore struct {
+	mu  sync.Mutex
+	ids map[string]time.Time
+	// sweepAt is the size at which expired IDs are dropped.
+	sweepAt int
+}
+
+func NewMemoryReplayStore() *MemoryReplayStore {
+	return &MemoryReplayStore{ids: map[string]time.Time{}, sweepAt: 1024}
+}
+
+func (store *MemoryReplayStore) Consume(id string, expires time.Time) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	t := now()
+	if exp, ok := store.ids[id]; ok && !t.After(exp) {
+		return ErrStateReplayed
+	}
+	if len(store.ids) >= store.sweepAt {
+		for other, exp := range store.ids {
+			if t.After(exp) {
+				delete(store.ids, other)
+			}
+		}
+		store.sweepAt = 2 * len(store.ids)
+		if store.sweepAt < 1024 {
+			store.sweepAt = 1024
+		}
+	}
+	store.ids[id] = expires
+	return nil
+}
+
+var defaultReplayStore = NewMemoryReplayStore()
+
+func (sa *scramAuth) exportState(key []byte, ttl time.Duration) (string, error) {
+	if sa.sNonce == nil || sa.gs2Header.Params == nil {
+		return "", errors.New("no conversation in flight")
+	}
+	aead, err := stateAEAD(key)
+	if err != nil {
+		return "", err
+	}
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	plain, err := json.Marshal(serverState{
+		ID:              id,
+		NonStd:          sa.gs2Header.NonStd,
+		CB:              sa.gs2Header.CB,
+		Authzid:         sa.gs2Header.Authzid,
+		SupportsCB:      sa.gs2Header.supportsCB,
+		Params:          sa.gs2Header.Params.All(),
+		SNonce:          sa.sNonce,
+		Salt:            sa.salt,
+		Iter:            sa.iter,
+		UnknownUser:     sa.unknownUser,
+		ClientFirstBare: sa.clientFirstBare,
+		ServerFirst:     sa.serverFirst,
+		Expires:         now().Add(ttl).Unix()})
+	if err != nil {
+		return "", err
+	}
+	out := make([]byte, 1+aead.NonceSize(), 1+aead.NonceSize()+len(plain)+aead.Overhead())
+	out[0] = stateVersion
+	if _, err := rand.Read(out[1:]); err != nil {
+		return "", err
+	}
+	out = aead.Seal(out, out[1:], plain, out[:1])
+	zero(plain)
+	return base64.RawURLEncoding.EncodeToString(out), nil
+}
+
+func (sa *scramAuth) resumeState(key []byte, token string) error {
+	aead, err := stateAEAD(key)
+	if err != nil {
+		return err
+	}
+	in, err := base64.RawURLEncoding.Strict().DecodeString(token)
+	if err != nil || len(in) < 1+aead.NonceSize() || in[0] != stateVersion {
+		return ErrInvalidState
+	}
+	nonce := in[1 : 1+aead.NonceSize()]
+	plain, err := aead.Open(nil, nonce, in[1+aead.NonceSize():], in[:1])
+	if err != nil {
+		return ErrInvalidState
+	}
+	defer zero(plain)
+	var state serverState
+	if err := json.Unmarshal(plain, &state); err != nil {
+		return ErrInvalidState
+	}
+	if now().Unix() > state.Expires {
+		return ErrStateExpired
+	}
+	replay := sa.replay
+	if replay == nil {
+		replay = defaultReplayStore
+	}
+	if err := replay.Consume(string(state.ID), time.Unix(state.Expires, 0)); err != nil {
+		return err
+	}
+	sa.gs2Header = Gs2Header{
+		NonStd:     state.NonStd,
+		CB:         state.CB,
+		Authzid:    state.Authzid,
+		Params:     NewParamsWith(state.Params),
+		supportsCB: state.SupportsCB}
+	sa.sNonce = state.SNonce
+	sa.salt = state.Salt
+	sa.iter = state.Iter
+	sa.unknownUser = state.UnknownUser
+	sa.clientFirstBare = state.ClientFirstBare
+	sa.serverFirst = state.ServerFirst
+	serverFirst := NewParams()
+	if err := NewStrictEncoding(ServerFirstGrammar).Parse(sa.serverFirst, serverFirst); err != nil {
+		return ErrInvalidState
+	}
+	sa.setAttrs(MsgClientFirst, sa.gs2Header.Params)
+	sa.setAttrs(MsgServerFirst, serverFirst)
+	sa.setExtensionsUser()
+	return nil
+}
+
+func stateAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,121 @@
+package scramauth
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"testing"
+	"time"
+)
+
+var stateKey = []byte("0123456789abcdef0123456789abcdef")
+
+func exportedChallenge(t *testing.T) (*ClientScramAuth, string, *bytes.Buffer) {
+	client := NewClientScramAuth(sha256.New, None, nil)
+	var req bytes.Buffer
+	if err := client.WriteReqMsg("", "yang-zhong", &req); err != nil {
+		t.Fatalf("write req msg error: %s", err.Error())
+	}
+	server := NewServerScramAuth(sha256.New, None, nil)
+	var challenge bytes.Buffer
+	if err := server.WriteChallengeMsg(&req, func(username []byte) ([]byte, int, error) {
+		return []byte("12345678"), 4096, nil
+	}, &challenge); err != nil {
+		t.Fatalf("write challenge msg error: %s", err.Error())
+	}
+	token, err := server.ExportState(stateKey, time.Minute)
+	if err != nil {
+		t.Fatalf("export state error: %s", err.Error())
+	}
+	return client, token, &challenge
+}
+
+func TestResumeState(t *testing.T) {
+	client, token, challenge := exportedChallenge(t)
+	var res bytes.Buffer
+	if err := client.WriteResMsg(challenge, "123456", &res); err != nil {
+		t.Fatalf("client response error: %s", err.Error())
+	}
+	server := NewServerScramAuth(sha256.New, None, nil)
+	if err := server.ResumeState(stateKey, token); err != nil {
+		t.Fatalf("resume state error: %s", err.Error())
+	}
+	saltedPassword := server.SaltedPassword([]byte("123456"), []byte("12345678"), 4096)
+	if err := server.Verify(bytes.NewBuffer(res.Bytes()), saltedPassword); err != nil {
+		t.Fatalf("server verify error: %s", err.Error())
+	}
+	var sig bytes.Buffer
+	if err := server.WriteSignatureMsg(&res, saltedPassword, &sig); err != nil {
+		t.Fatalf("server signature error: %s", err.Error())
+	}
+	if err := client.Verify(&sig); err != nil {
+		t.Fatalf("client verify error: %s", err.Error())
+	}
+}
+
+func TestResumeStateRejectsBadTokens(t *testing.T) {
+	_, token, _ := exportedChallenge(t)
+	server := NewServerScramAuth(sha256.New, None, nil)
+	tampered := []byte(token)
+	tampered[len(tampered)/2] ^= 1
+	if err := server.ResumeState(stateKey, string(tampered)); !errors.Is(err, ErrInvalidState) {
+		t.Fatalf("expected ErrInvalidState for tampered token, got %v", err)
+	}
+	if err := server.ResumeState([]byte("fedcba9876543210fedcba9876543210"), token); !errors.Is(err, ErrInvalidState) {
+		t.Fatalf("expected ErrInvalidState for wrong key, got %v", err)
+	}
+	defer func() { now = time.Now }()
+	now = func() time.Time { return time.Now().Add(2 * time.Minute) }
+	if err := server.ResumeState(stateKey, token); !errors.Is(err, ErrStateExpired) {
+		t.Fatalf("expected ErrStateExpired, got %v", err)
+	}
+}
+
+func TestExportStateWithoutConversation(t *testing.T) {
+	server := NewServerScramAuth(sha256.New, None, nil)
+	if _, err := server.ExportState(stateKey, time.Minute); err == nil {
+		t.Fatalf("export without conversation should fail")
+	}
+}
+
+func TestResumeStateOnce(t *testing.T) {
+	client, token, challenge := exportedChallenge(t)
+	var res bytes.Buffer
+	if err := client.WriteResMsg(challenge, "123456", &res); err != nil {
+		t.Fatalf("client response error: %s", err.Error())
+	}
+	store := NewMemoryReplayStore()
+	if err := NewServerScramAuth(sha256.New, None, nil).WithReplayStore(store).ResumeState(stateKey, token); err != nil {
+		t.Fatalf("resume state error: %s", err.Error())
+	}
+	if err := NewServerScramAuth(sha256.New, None, nil).WithReplayStore(store).ResumeState(stateKey, token); !errors.Is(err, ErrStateReplayed) {
+		t.Fatalf("expected ErrStateReplayed, got %v", err)
+	}
+	server := NewServerScramAuth(sha256.New, None, nil)
+	if err := server.ResumeState(stateKey, token); err != nil {
+		t.Fatalf("resume state with the default store error: %s", err.Error())
+	}
+	if err := server.ResumeState(stateKey, token); !errors.Is(err, ErrStateReplayed) {
+		t.Fatalf("expected ErrStateReplayed from the default store, got %v", err)
+	}
+}
+
+func TestMemoryReplayStoreForgetsExpired(t *testing.T) {
+	clock := time.Unix(1700000000, 0)
+	now = func() time.Time { return clock }
+	defer func() { now = time.Now }()
+	store := NewMemoryReplayStore()
+	for i := 0; i < 1024; i++ {
+		store.Consume(string(rune(i)), clock.Add(time.Minute))
+	}
+	clock = clock.Add(2 * time.Minute)
+	if err := store.Consume("fresh", clock.Add(time.Minute)); err != nil {
+		t.Fatalf("consume error: %s", err.Error())
+	}
+	if len(store.ids) != 1 {
+		t.Fatalf("expected expired IDs to be dropped, %d left", len(store.ids))
+	}
+	if err := store.Consume(string(rune(0)), clock.Add(time.Minute)); err != nil {
+		t.Fatalf("expected an expired ID to be forgotten, got %v", err)
+	}
+}
@@ -0,0 +1,145 @@
+package scramauth
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"sync"
+	"time"
+)
+
+var digitsPower = []uint32{1, 10, 100, 1000, 10000, 100000, 1000000, 10000000, 100000000, 1000000000}
+
+// ErrInvalidOTP is returned by TOTP.Validate for a wrong one-time password.
+var ErrInvalidOTP = errors.New("invalid one-time password")
+
+// ErrOTPReused is returned by TOTP.Validate for a password of a time step
+// at or before the last one used.
+var ErrOTPReused = fmt.Errorf("%w: already used", ErrInvalidOTP)
+
+// HOTP computes the RFC 4226 one-time password of secret for counter with
+// the given number of digits, 6 to 9, using HMAC over h (SHA-1 when nil).
+func HOTP(h func() hash.Hash, secret []byte, counter uint64, digits int) (string, error) {
+	if digits < 6 || digits >= len(digitsPower) {
+		return "", fmt.Errorf("HOTP digits must be 6 to 9, not %d", digits)
+	}
+	if h == nil {
+		h = sha1.New
+	}
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], counter)
+	m := hmac.New(h, secret)
+	m.Write(msg[:])
+	sum := m.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	code := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", digits, code%digitsPower[digits]), nil
+}
+
+// TOTP generates and checks RFC 6238 time-based one-time passwords.
+type TOTP struct {
+	Secret []byte
+	// Hash defaults to SHA-1, Digits to 6 and Period, a whole number of
+	// seconds, to 30 seconds.
+	Hash   func() hash.Hash
+	Digits int
+	Period time.Duration
+	// Skew is how many periods before and after the current one are
+	// accepted, to allow for clock drift.
+	Skew int
+	// Used, when set, remembers the last time step a password was accepted
+	// for, so that a password can't be used twice (RFC 6238 section 5.2).
+	// It should be kept per user with the secret.
+	Used OTPCounter
+}
+
+// OTPCounter keeps the last time step a user's password was accepted for.
+type OTPCounter interface {
+	// Advance records counter as the last time step used and returns true
+	// when it is after the one recorded, otherwise it returns false and
+	// leaves the record alone. It must be atomic.
+	Advance(counter uint64) (bool, error)
+}
+
+// LastCounter is an in-memory OTPCounter, safe for concurrent use.
+type LastCounter struct {
+	mu   sync.Mutex
+	last uint64
+	used bool
+}
+
+func (lc *LastCounter) Advance(counter uint64) (bool, error) {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	if lc.used && counter <= lc.last {
+		return false, nil
+	}
+	lc.last, lc.used = counter, true
+	return true, nil
+}
+
+func (totp *TOTP) digits() int {
+	if totp.Digits == 0 {
+		return 6
+	}
+	return totp.Digits
+}
+
+func (totp *TOTP) counter(at time.Time) (uint64, error) {
+	period := totp.Period
+	if period == 0 {
+		period = 30 * time.Second
+	}
+	if period < time.Second || period%time.Second != 0 {
+		return 0, fmt.Errorf("TOTP period must be a whole number of seconds, not %s", period)
+	}
+	return uint64(at.Unix() / int64(period/time.Second)), nil
+}
+
+// Generate returns the one-time password valid at the given time.
+func (totp *TOTP) Generate(at time.Time) (string, error) {
+	counter, err := totp.counter(at)
+	if err != nil {
+		return "", err
+	}
+	return HOTP(totp.Hash, totp.Secret, counter, totp.digits())
+}
+
+// Validate checks otp is valid at the given time, within Skew, and returns
+// ErrInvalidOTP when it isn't, or ErrOTPReused when Used already saw its
+// time step.
+func (totp *TOTP) Validate(otp string, at time.Time) error {
+	counter, err := totp.counter(at)
+	if err != nil {
+		return err
+	}
+	valid, matched := 0, uint64(0)
+	for i := -totp.Skew; i <= totp.Skew; i++ {
+		step := counter + uint64(int64(i))
+		code, err := HOTP(totp.Hash, totp.Secret, step, totp.digits())
+		if err != nil {
+			return err
+		}
+		if subtle.ConstantTimeCompare([]byte(code), []byte(otp)) == 1 {
+			valid, matched = 1, step
+		}
+	}
+	if valid != 1 {
+		return ErrInvalidOTP
+	}
+	if totp.Used == nil {
+		return nil
+	}
+	advanced, err := totp.Used.Advance(matched)
+	if err != nil {
+		return err
+	}
+	if !advanced {
+		return ErrOTPReused
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+package scramauth
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"errors"
+	"hash"
+	"testing"
+	"time"
+)
+
+// RFC 6238 appendix B
+func TestTOTPVectors(t *testing.T) {
+	seeds := map[string][]byte{
+		"sha1":   []byte("12345678901234567890"),
+		"sha256": []byte("12345678901234567890123456789012"),
+		"sha512": []byte("1234567890123456789012345678901234567890123456789012345678901234"),
+	}
+	hashes := map[string]func() hash.Hash{"sha1": sha1.New, "sha256": sha256.New, "sha512": sha512.New}
+	for _, c := range []struct {
+		at   int64
+		h    string
+		want string
+	}{
+		{59, "sha1", "94287082"},
+		{59, "sha256", "46119246"},
+		{59, "sha512", "90693936"},
+		{1111111109, "sha1", "07081804"},
+		{1111111109, "sha256", "68084774"},
+		{1111111109, "sha512", "25091201"},
+		{1234567890, "sha1", "89005924"},
+		{2000000000, "sha256", "90698825"},
+		{20000000000, "sha512", "47863826"},
+	} {
+		totp := &TOTP{Secret: seeds[c.h], Hash: hashes[c.h], Digits: 8}
+		if got, _ := totp.Generate(time.Unix(c.at, 0)); got != c.want {
+			t.Fatalf("%s at %d: expected %s, got %s", c.h, c.at, c.want, got)
+		}
+	}
+}
+
+// RFC 4226 appendix D
+func TestHOTPVectors(t *testing.T) {
+	want := []string{"755224", "287082", "359152", "969429", "338314"}
+	for i, w := range want {
+		if got, _ := HOTP(nil, []byte("12345678901234567890"), uint64(i), 6); got != w {
+			t.Fatalf("counter %d: expected %s, got %s", i, w, got)
+		}
+	}
+}
+
+func TestTOTPValidate(t *testing.T) {
+	totp := &TOTP{Secret: []byte("12345678901234567890"), Skew: 1}
+	at := time.Unix(1111111109, 0)
+	previous, _ := totp.Generate(at.Add(-30 * time.Second))
+	if err := totp.Validate(previous, at); err != nil {
+		t.Fatalf("previous period not accepted within skew: %s", err.Error())
+	}
+	old, _ := totp.Generate(at.Add(-90 * time.Second))
+	if err := totp.Validate(old, at); err != ErrInvalidOTP {
+		t.Fatalf("expected ErrInvalidOTP outside of skew, got %v", err)
+	}
+}
+
+func TestTOTPReused(t *testing.T) {
+	totp := &TOTP{Secret: []byte("12345678901234567890"), Skew: 1, Used: &LastCounter{}}
+	at := time.Unix(1111111109, 0)
+	current, _ := totp.Generate(at)
+	if err := totp.Validate(current, at); err != nil {
+		t.Fatalf("validate error: %s", err.Error())
+	}
+	if err := totp.Validate(current, at); !errors.Is(err, ErrOTPReused) {
+		t.Fatalf("expected ErrOTPReused for the same password, got %v", err)
+	}
+	previous, _ := totp.Generate(at.Add(-30 * time.Second))
+	if err := totp.Validate(previous, at); !errors.Is(err, ErrOTPReused) {
+		t.Fatalf("expected ErrOTPReused for an earlier password, got %v", err)
+	}
+	next, _ := totp.Generate(at.Add(30 * time.Second))
+	if err := totp.Validate(next, at.Add(30*time.Second)); err != nil {
+		t.Fatalf("validate error for the next period: %s", err.Error())
+	}
+}
+
+func TestTOTPPeriod(t *testing.T) {
+	for _, period := range []time.Duration{-time.Second, time.Millisecond, 500 * time.Millisecond, 1500 * time.Millisecond} {
+		totp := &TOTP{Secret: []byte("12345678901234567890"), Period: period}
+		if _, err := totp.Generate(time.Unix(59, 0)); err == nil {
+			t.Fatalf("expected an error for a period of %s", period)
+		}
+		if err := totp.Validate("287082", time.Unix(59, 0)); err == nil || err == ErrInvalidOTP {
+			t.Fatalf("expected a period error for %s, got %v", period, err)
+		}
+	}
+	totp := &TOTP{Secret: []byte("12345678901234567890"), Period: time.Second}
+	if _, err := totp.Generate(time.Unix(59, 0)); err != nil {
+		t.Fatalf("generate error for a 1s period: %s", err.Error())
+	}
+}
+
+func TestHOTPDigits(t *testing.T) {
+	for _, digits := range []int{-1, 0, 5, 10, 11} {
+		if _, err := HOTP(nil, []byte("12345678901234567890"), 0, digits); err == nil {
+			t.Fatalf("expected an error for %d digits", digits)
+		}
+	}
+	totp := &TOTP{Secret: []byte("12345678901234567890"), Digits: 10}
+	if _, err := totp.Generate(time.Unix(59, 0)); err == nil {
+		t.Fatalf("expected an error for 10 digits")
+	}
+	if err := totp.Validate("1234567890", time.Unix(59, 0)); err == nil || err == ErrInvalidOTP {
+		t.Fatalf("expected a digits error, got %v", err)
+	}
+}
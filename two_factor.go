@@ -0,0 +1,141 @@
+package scramauth
+
+import "fmt"
+
+// TwoFactorAttr is the extension attribute of the second factor, modelled
+// on draft-melnikov-scram-2fa: in the server-first message it names the
+// method the server asks for, in the client-final message it carries the
+// one-time password.
+const TwoFactorAttr = 't'
+
+// ErrSecondFactor is wrapped by the errors of a failed second factor. The
+// client is told invalid-proof, so it can't tell whether the password was
+// right.
+var ErrSecondFactor = fmt.Errorf("%w: second factor", ErrInvalidProof)
+
+// TOTPMethod is the method TOTPVerifier announces.
+const TOTPMethod = "totp"
+
+// OTPVerifier checks the second factor of users.
+type OTPVerifier interface {
+	// Method returns the one-time password method of username, e.g.
+	// "totp", or "" when the user has no second factor.
+	Method(username []byte) (string, error)
+	// DecoyMethod returns the method users without a second factor are
+	// asked for, so that the server-first message doesn't tell who has
+	// one. It should be the one Method returns for most users.
+	DecoyMethod() string
+	Verify(username []byte, otp string) error
+}
+
+// TOTPVerifier is an OTPVerifier of RFC 6238 one-time passwords. It
+// returns the TOTP of a user, or nil when the user has no second factor.
+// The TOTP should have Used set, otherwise a password can be replayed
+// within its period.
+type TOTPVerifier func(username []byte) (*TOTP, error)
+
+func (find TOTPVerifier) Method(username []byte) (string, error) {
+	totp, err := find(username)
+	if err != nil || totp == nil {
+		return "", err
+	}
+	return TOTPMethod, nil
+}
+
+func (find TOTPVerifier) DecoyMethod() string {
+	return TOTPMethod
+}
+
+func (find TOTPVerifier) Verify(username []byte, otp string) error {
+	totp, err := find(username)
+	if err != nil {
+		return err
+	}
+	if totp == nil {
+		return ErrInvalidOTP
+	}
+	return totp.Validate(otp, now())
+}
+
+type twoFactorServer struct {
+	verifier OTPVerifier
+	username []byte
+}
+
+// NewTwoFactorServer returns a server extension asking every user for a
+// one-time password, checked once the proof is valid for users that have a
+// second factor. Users without one, unknown users included, are asked for
+// the DecoyMethod of verifier and their password isn't checked. It keeps the state of a single conversation.
+func NewTwoFactorServer(verifier OTPVerifier) Extension {
+	return &twoFactorServer{verifier: verifier}
+}
+
+func (ext *twoFactorServer) SetUser(username, authzid []byte) {
+	ext.username = username
+}
+
+func (ext *twoFactorServer) Write(kind MessageKind) ([]Param, error) {
+	if kind != MsgServerFirst {
+		return nil, nil
+	}
+	method, err := ext.verifier.Method(ext.username)
+	if err != nil {
+		return nil, err
+	}
+	if method == "" {
+		method = ext.verifier.DecoyMethod()
+	}
+	return []Param{{Key: []byte{TwoFactorAttr}, Val: []byte(method)}}, nil
+}
+
+// Read asks the verifier again rather than trusting the method sent in the
+// server-first message, which a resumed conversation doesn't know.
+func (ext *twoFactorServer) Read(kind MessageKind, attrs *Params) error {
+	if kind != MsgClientFinal {
+		return nil
+	}
+	method, err := ext.verifier.Method(ext.username)
+	if err != nil || method == "" {
+		return err
+	}
+	otp, ok := attrs.Val([]byte{TwoFactorAttr})
+	if !ok {
+		return fmt.Errorf("%w: missing one-time password", ErrSecondFactor)
+	}
+	if err := ext.verifier.Verify(ext.username, string(otp)); err != nil {
+		return fmt.Errorf("%w: %v", ErrSecondFactor, err)
+	}
+	return nil
+}
+
+type twoFactorClient struct {
+	otp    func(method string) (string, error)
+	method string
+}
+
+// NewTwoFactorClient returns a client extension answering a server asking
+// for a second factor with the one-time password returned by otp. Servers
+// ask users without a second factor too, any password will do for them.
+// It keeps the state of a single conversation.
+func NewTwoFactorClient(otp func(method string) (string, error)) Extension {
+	return &twoFactorClient{otp: otp}
+}
+
+func (ext *twoFactorClient) Read(kind MessageKind, attrs *Params) error {
+	if kind == MsgServerFirst {
+		method, _ := attrs.Val([]byte{TwoFactorAttr})
+		ext.method = string(method)
+	}
+	return nil
+}
+
+func (ext *twoFactorClient) Write(kind MessageKind) ([]Param, error) {
+	if kind != MsgClientFinal || ext.method == "" {
+		return nil, nil
+	}
+	otp, err := ext.otp(ext.method)
+	if err != nil {
+		return nil, err
+	}
+	return []Param{{Key: []byte{TwoFactorAttr}, Val: []byte(otp)}}, nil
+}
@@ -0,0 +1,137 @@
+package scramauth
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestTwoFactor(t *testing.T) {
+	totp := &TOTP{Secret: []byte("12345678901234567890")}
+	verifier := TOTPVerifier(func(username []byte) (*TOTP, error) {
+		return totp, nil
+	})
+	asked := ""
+	client := NewClientScramAuth(sha256.New, None, nil).WithExtension(NewTwoFactorClient(func(method string) (string, error) {
+		asked = method
+		return totp.Generate(time.Now())
+	}))
+	server := NewServerScramAuth(sha256.New, None, nil).WithExtension(NewTwoFactorServer(verifier))
+	if err := runExchange(client, server, "123456", passwordKeys("12345678", 4096)); err != nil {
+		t.Fatalf("exchange error: %s", err.Error())
+	}
+	if asked != TOTPMethod {
+		t.Fatalf("client wasn't asked for a totp: %q", asked)
+	}
+	if otp, _ := server.Attrs(MsgClientFinal).Val([]byte{TwoFactorAttr}); len(otp) != 6 {
+		t.Fatalf("unexpected one-time password: %s", otp)
+	}
+
+	client = NewClientScramAuth(sha256.New, None, nil).WithExtension(NewTwoFactorClient(func(method string) (string, error) {
+		return "000000", nil
+	}))
+	server = NewServerScramAuth(sha256.New, None, nil).WithExtension(NewTwoFactorServer(verifier))
+	if err := runExchange(client, server, "123456", passwordKeys("12345678", 4096)); !errors.Is(err, ErrInvalidProof) {
+		t.Fatalf("expected invalid-proof, got %v", err)
+	}
+}
+
+func TestTwoFactorMissing(t *testing.T) {
+	verifier := TOTPVerifier(func(username []byte) (*TOTP, error) {
+		return &TOTP{Secret: []byte("12345678901234567890")}, nil
+	})
+	client := NewClientScramAuth(sha256.New, None, nil)
+	var req bytes.Buffer
+	if err := client.WriteReqMsg("", "yang-zhong", &req); err != nil {
+		t.Fatalf("write req msg error: %s", err.Error())
+	}
+	server := NewServerScramAuth(sha256.New, None, nil).WithExtension(NewTwoFactorServer(verifier))
+	var challenge bytes.Buffer
+	if err := server.WriteChallengeMsg(&req, func(username []byte) ([]byte, int, error) {
+		return []byte("12345678"), 4096, nil
+	}, &challenge); err != nil {
+		t.Fatalf("write challenge msg error: %s", err.Error())
+	}
+	var res bytes.Buffer
+	if err := client.WriteResMsg(&challenge, "123456", &res); err != nil {
+		t.Fatalf("client response error: %s", err.Error())
+	}
+	saltedPassword := server.SaltedPassword([]byte("123456"), []byte("12345678"), 4096)
+	if err := server.Verify(&res, saltedPassword); !errors.Is(err, ErrSecondFactor) {
+		t.Fatalf("expected ErrSecondFactor, got %v", err)
+	}
+}
+
+func TestTwoFactorNotEnrolled(t *testing.T) {
+	verifier := TOTPVerifier(func(username []byte) (*TOTP, error) {
+		return nil, nil
+	})
+	asked := ""
+	client := NewClientScramAuth(sha256.New, None, nil).WithExtension(NewTwoFactorClient(func(method string) (string, error) {
+		asked = method
+		return "000000", nil
+	}))
+	server := NewServerScramAuth(sha256.New, None, nil).WithExtension(NewTwoFactorServer(verifier))
+	if err := runExchange(client, server, "123456", passwordKeys("12345678", 4096)); err != nil {
+		t.Fatalf("exchange error: %s", err.Error())
+	}
+	if asked != TOTPMethod {
+		t.Fatalf("user without a second factor wasn't asked for %q: %q", TOTPMethod, asked)
+	}
+	server = NewServerScramAuth(sha256.New, None, nil).WithExtension(NewTwoFactorServer(verifier))
+	if err := runExchange(NewClientScramAuth(sha256.New, None, nil), server, "123456", passwordKeys("12345678", 4096)); err != nil {
+		t.Fatalf("exchange without a one-time password error: %s", err.Error())
+	}
+}
+
+type hotpVerifier struct{}
+
+func (hotpVerifier) Method(username []byte) (string, error) {
+	return "", nil
+}
+
+func (hotpVerifier) DecoyMethod() string {
+	return "hotp"
+}
+
+func (hotpVerifier) Verify(username []byte, otp string) error {
+	return ErrInvalidOTP
+}
+
+func TestTwoFactorDecoyOfVerifier(t *testing.T) {
+	asked := ""
+	client := NewClientScramAuth(sha256.New, None, nil).WithExtension(NewTwoFactorClient(func(method string) (string, error) {
+		asked = method
+		return "000000", nil
+	}))
+	server := NewServerScramAuth(sha256.New, None, nil).WithExtension(NewTwoFactorServer(hotpVerifier{}))
+	if err := runExchange(client, server, "123456", passwordKeys("12345678", 4096)); err != nil {
+		t.Fatalf("exchange error: %s", err.Error())
+	}
+	if asked != "hotp" {
+		t.Fatalf("user without a second factor wasn't asked for the verifier's method: %q", asked)
+	}
+}
+
+func TestTwoFactorFailuresLockOut(t *testing.T) {
+	totp := &TOTP{Secret: []byte("12345678901234567890")}
+	verifier := TOTPVerifier(func(username []byte) (*TOTP, error) {
+		return totp, nil
+	})
+	limiter := NewMemoryAttemptLimiter(AttemptPolicy{MaxFailures: 3, Lockout: time.Hour})
+	exchange := func(otp func(method string) (string, error)) error {
+		client := NewClientScramAuth(sha256.New, None, nil).WithExtension(NewTwoFactorClient(otp))
+		server := NewServerScramAuth(sha256.New, None, nil).WithExtension(NewTwoFactorServer(verifier)).WithAttemptLimiter(limiter)
+		return runExchange(client, server, "123456", passwordKeys("12345678", 4096))
+	}
+	for i := 0; i < 3; i++ {
+		if err := exchange(func(method string) (string, error) { return "000000", nil }); !errors.Is(err, ErrInvalidProof) {
+			t.Fatalf("attempt %d: expected invalid-proof, got %v", i+1, err)
+		}
+	}
+	if err := exchange(func(method string) (string, error) { return totp.Generate(time.Now()) }); !errors.Is(err, ErrTemporarilyLocked) {
+		t.Fatalf("expected the user to be locked out after wrong one-time passwords, got %v", err)
+	}
+}
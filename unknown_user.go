@@ -0,0 +1,33 @@
+package scramauth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+)
+
+const fakeSaltLen = 16
+
+type unknownUserSimulation struct {
+	secret []byte
+	iter   int
+}
+
+func (sim *unknownUserSimulation) mac(label string, username []byte, extra ...byte) []byte {
+	m := hmac.New(sha256.New, sim.secret)
+	m.Write([]byte(label))
+	m.Write(username)
+	m.Write(extra)
+	return m.Sum(nil)
+}
+
+// salt derives a salt that is stable for username, so repeated attempts
+// can't tell a simulated user from a real one.
+func (sim *unknownUserSimulation) salt(username []byte) []byte {
+	return sim.mac("salt", username)[:fakeSaltLen]
+}
+
+// password stands in for the user's password when Verify derives a key for
+// a simulated user.
+func (sim *unknownUserSimulation) password(username []byte) []byte {
+	return sim.mac("password", username)
+}
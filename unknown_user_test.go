@@ -0,0 +1,102 @@
+package scramauth
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"testing"
+)
+
+func simulatedChallenge(t *testing.T, username string) (*ClientScramAuth, *ServerScramAuth, *bytes.Buffer) {
+	client := NewClientScramAuth(sha256.New, None, nil)
+	var req bytes.Buffer
+	if err := client.WriteReqMsg("", username, &req); err != nil {
+		t.Fatalf("write req msg error: %s", err.Error())
+	}
+	server := NewServerScramAuth(sha256.New, None, nil).WithUnknownUserSimulation([]byte("server-secret"), 4096)
+	var challenge bytes.Buffer
+	if err := server.WriteChallengeMsg(&req, func(username []byte) ([]byte, int, error) {
+		return nil, 0, ErrUnknownUser
+	}, &challenge); err != nil {
+		t.Fatalf("write challenge msg error: %s", err.Error())
+	}
+	return client, server, &challenge
+}
+
+func TestUnknownUserSimulation(t *testing.T) {
+	client, server, challenge := simulatedChallenge(t, "nobody")
+	salt := server.scramAuth.salt
+	if len(salt) == 0 || server.scramAuth.iter != 4096 {
+		t.Fatalf("unexpected simulated salt/iter: %q %d", salt, server.scramAuth.iter)
+	}
+	var res bytes.Buffer
+	if err := client.WriteResMsg(challenge, "123456", &res); err != nil {
+		t.Fatalf("client response error: %s", err.Error())
+	}
+	var derived []int
+	server.WithKDF(&recordingKDF{PBKDF2: PBKDF2{Hash: sha256.New}, iters: &derived})
+	if err := server.Verify(&res, nil); !errors.Is(err, ErrInvalidProof) {
+		t.Fatalf("expected ErrInvalidProof, got %v", err)
+	}
+	if len(derived) != 1 || derived[0] != 4096 {
+		t.Fatalf("expected a key derived at 4096 iterations for the simulated user, got %v", derived)
+	}
+
+	_, again, _ := simulatedChallenge(t, "nobody")
+	if !bytes.Equal(salt, again.scramAuth.salt) {
+		t.Fatalf("simulated salt is not stable")
+	}
+	_, other, _ := simulatedChallenge(t, "somebody")
+	if bytes.Equal(salt, other.scramAuth.salt) {
+		t.Fatalf("simulated salt doesn't depend on username")
+	}
+}
+
+func TestUnknownUserWithoutSimulation(t *testing.T) {
+	client := NewClientScramAuth(sha256.New, None, nil)
+	var req bytes.Buffer
+	if err := client.WriteReqMsg("", "nobody", &req); err != nil {
+		t.Fatalf("write req msg error: %s", err.Error())
+	}
+	server := NewServerScramAuth(sha256.New, None, nil)
+	err := server.WriteChallengeMsg(&req, func(username []byte) ([]byte, int, error) {
+		return nil, 0, ErrUnknownUser
+	}, &bytes.Buffer{})
+	if !errors.Is(err, ErrUnknownUser) {
+		t.Fatalf("expected ErrUnknownUser, got %v", err)
+	}
+}
+
+func TestWrongPasswordIsInvalidProof(t *testing.T) {
+	client := NewClientScramAuth(sha256.New, None, nil)
+	var req bytes.Buffer
+	if err := client.WriteReqMsg("", "yang-zhong", &req); err != nil {
+		t.Fatalf("write req msg error: %s", err.Error())
+	}
+	server := NewServerScramAuth(sha256.New, None, nil)
+	var challenge bytes.Buffer
+	if err := server.WriteChallengeMsg(&req, func(username []byte) ([]byte, int, error) {
+		return []byte("12345678"), 4096, nil
+	}, &challenge); err != nil {
+		t.Fatalf("write challenge msg error: %s", err.Error())
+	}
+	var res bytes.Buffer
+	if err := client.WriteResMsg(&challenge, "wrong", &res); err != nil {
+		t.Fatalf("client response error: %s", err.Error())
+	}
+	saltedPassword := server.SaltedPassword([]byte("123456"), []byte("12345678"), 4096)
+	if err := server.Verify(&res, saltedPassword); !errors.Is(err, ErrInvalidProof) {
+		t.Fatalf("expected ErrInvalidProof, got %v", err)
+	}
+}
+
+type recordingKDF struct {
+	PBKDF2
+	iters *[]int
+}
+
+func (kdf *recordingKDF) Key(ctx context.Context, password, salt []byte, iter, keyLen int) ([]byte, error) {
+	*kdf.iters = append(*kdf.iters, iter)
+	return kdf.PBKDF2.Key(ctx, password, salt, iter, keyLen)
+}